@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	allow, err := parsePrefixes("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := parsePrefixes("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := accessList{allow: allow, deny: deny}
+
+	for _, test := range []struct {
+		ip   string
+		want bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.2.3", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"::ffff:10.2.3.4", true},
+	} {
+		addr := &net.TCPAddr{IP: net.ParseIP(test.ip), Port: 1234}
+		if got := access.permits(addr); got != test.want {
+			t.Errorf("permits(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+	if !access.permits(&net.UnixAddr{Name: "/tmp/pixelflut.sock", Net: "unix"}) {
+		t.Error("a Unix socket client was rejected")
+	}
+}
+
+func TestDenyRejectsConnection(t *testing.T) {
+	for _, test := range []struct {
+		allow, deny string
+		rejected    bool
+	}{
+		{deny: "127.0.0.0/8", rejected: true},
+		{allow: "10.0.0.0/8", rejected: true},
+		{allow: "127.0.0.1"},
+		{allow: "127.0.0.0/8", deny: "10.0.0.0/8"},
+	} {
+		g := newTestGame(t, 2, 2)
+		g.access.allow, _ = parsePrefixes(test.allow)
+		g.access.deny, _ = parsePrefixes(test.deny)
+		c := dial(t, "tcp", listen(t, g))
+
+		if !test.rejected {
+			c.do("PX 0 0 ff0000")
+			continue
+		}
+		if got := c.readLine(); got != "ERROR forbidden" {
+			t.Errorf("allow %q, deny %q: rejected client got %q", test.allow, test.deny, got)
+		}
+		if _, err := c.r.ReadString('\n'); err == nil {
+			t.Errorf("allow %q, deny %q: connection stayed open", test.allow, test.deny)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// adaptiveRefresh lowers the display refresh rate while the pixel rate is
+// above a threshold. Pixel updates are still applied to the canvas every
+// frame; only uploading them to the display is deferred, so nothing is lost.
+type adaptiveRefresh struct {
+	threshold float64       // pixels per second
+	interval  time.Duration // between uploads while throttled
+
+	throttled  bool
+	lastUpload time.Time
+}
+
+// shouldUpload reports whether the frame should be uploaded to the display
+// at now, given the current pixel rate. It must only be called on the render
+// goroutine.
+func (a *adaptiveRefresh) shouldUpload(now time.Time, pixelsPerSec float64) bool {
+	if throttled := pixelsPerSec > a.threshold; throttled != a.throttled {
+		a.throttled = throttled
+		if throttled {
+			log.Printf("%.0f pixels/s, lowering the display refresh rate", pixelsPerSec)
+		} else {
+			log.Printf("%.0f pixels/s, restoring the display refresh rate", pixelsPerSec)
+		}
+	}
+
+	if a.throttled && now.Sub(a.lastUpload) < a.interval {
+		return false
+	}
+	a.lastUpload = now
+	return true
+}
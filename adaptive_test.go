@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRefresh(t *testing.T) {
+	logged := captureLog(t)
+	a := &adaptiveRefresh{threshold: 1000, interval: 100 * time.Millisecond}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// uploads during a second of 60 frames at the given pixel rate
+	uploads := func(pixelsPerSec float64) int {
+		n := 0
+		for frame := 0; frame < 60; frame++ {
+			if a.shouldUpload(now, pixelsPerSec) {
+				n++
+			}
+			now = now.Add(time.Second / 60)
+		}
+		return n
+	}
+
+	if n := uploads(500); n != 60 {
+		t.Errorf("below the threshold, %d of 60 frames were uploaded", n)
+	}
+	// one in 7 frames is the first at least 100ms after the last upload
+	if n := uploads(5000); n < 8 || n > 9 {
+		t.Errorf("above the threshold, %d of 60 frames were uploaded, want 8 or 9", n)
+	}
+	if n := uploads(500); n != 60 {
+		t.Errorf("back below the threshold, %d of 60 frames were uploaded", n)
+	}
+	if !strings.Contains(logged.String(), "lowering") || !strings.Contains(logged.String(), "restoring") {
+		t.Errorf("the rate changes weren't logged: %q", logged.String())
+	}
+}
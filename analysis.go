@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"time"
+)
+
+// averageColor returns the mean color of the canvas pixels in r, clipped to
+// the canvas. ok is false if r doesn't overlap the canvas.
+func (g *Game) averageColor(r image.Rectangle) (avg color.RGBA, ok bool) {
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+
+	r = r.Intersect(g.canvas.Rect)
+	if r.Empty() {
+		return color.RGBA{}, false
+	}
+
+	var sum [4]uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := g.canvas.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum[0] += uint64(g.canvas.Pix[i])
+			sum[1] += uint64(g.canvas.Pix[i+1])
+			sum[2] += uint64(g.canvas.Pix[i+2])
+			sum[3] += uint64(g.canvas.Pix[i+3])
+			i += 4
+		}
+	}
+
+	n := uint64(r.Dx() * r.Dy())
+	return color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)}, true
+}
+
+// coverage returns the fraction of canvas pixels that differ from the black
+// background, whether never drawn or cleared.
+func (g *Game) coverage() float64 {
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+
+	covered := 0
+	for i := 0; i < len(g.canvas.Pix); i += 4 {
+		if g.canvas.Pix[i]|g.canvas.Pix[i+1]|g.canvas.Pix[i+2] != 0 {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(g.canvas.Pix)/4)
+}
+
+// dominantColor returns the most frequent color of the canvas and the
+// fraction of pixels that have it. Colors are counted with 4 bits per
+// channel, so slight variations of a color add up; the result is the mean of
+// the pixels in the winning bucket.
+func (g *Game) dominantColor() (color.RGBA, float64) {
+	var counts [1 << 12]uint32
+	var sums [1 << 12][3]uint64
+
+	g.canvasMu.RLock()
+	pix := g.canvas.Pix
+	for i := 0; i < len(pix); i += 4 {
+		bucket := int(pix[i]>>4)<<8 | int(pix[i+1]>>4)<<4 | int(pix[i+2]>>4)
+		counts[bucket]++
+		sums[bucket][0] += uint64(pix[i])
+		sums[bucket][1] += uint64(pix[i+1])
+		sums[bucket][2] += uint64(pix[i+2])
+	}
+	g.canvasMu.RUnlock()
+
+	best := 0
+	for bucket, n := range counts {
+		if n > counts[best] {
+			best = bucket
+		}
+	}
+	n := uint64(counts[best])
+	if n == 0 {
+		return color.RGBA{}, 0
+	}
+	c := color.RGBA{uint8(sums[best][0] / n), uint8(sums[best][1] / n), uint8(sums[best][2] / n), 255}
+	return c, float64(n) / float64(len(pix)/4)
+}
+
+// logCoverage logs the canvas coverage every interval until shutdown.
+func (g *Game) logCoverage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			log.Printf("Canvas coverage: %.2f%%", g.coverage()*100)
+		}
+	}
+}
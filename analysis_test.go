@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// drawGradient draws columns that get redder from left to right, 50 per
+// column.
+func drawGradient(t *testing.T, g *Game) {
+	t.Helper()
+	c := connect(t, g)
+	width, height := g.size()
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			c.send(fmt.Sprintf("PX %d %d %02x0000", x, y, x*50))
+		}
+	}
+	c.sync()
+	g.Render()
+}
+
+func TestAverageGradient(t *testing.T) {
+	g := newTestGame(t, 5, 3)
+	drawGradient(t, g)
+	c := connect(t, g)
+
+	tests := []struct {
+		cmd, want string
+	}{
+		{"AVG 2 1 1", "AVG 2 1 1 640000"},
+		{"AVG 2 1 0", "AVG 2 1 0 640000"},
+		{"AVG 4 1 0", "AVG 4 1 0 c80000"},
+		// clipped to the three leftmost columns
+		{"AVG 0 1 2", "AVG 0 1 2 320000"},
+	}
+	for _, test := range tests {
+		if got := c.do(test.cmd); len(got) != 1 || got[0] != test.want {
+			t.Errorf("%s = %q, want %q", test.cmd, got, test.want)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	g := newTestGame(t, 4, 5)
+	c := connect(t, g)
+	if got := c.do("COVERAGE"); len(got) != 1 || got[0] != "COVERAGE 0.0000" {
+		t.Errorf("COVERAGE of an empty canvas = %q", got)
+	}
+
+	// black doesn't count, it looks like the background
+	c.do("PX 0 0 ff0000", "PX 1 0 010101", "PX 2 0 000000", "PX 3 3 00ff0080", "PX 0 4 0000ff")
+	g.Render()
+	if got := c.do("COVERAGE"); len(got) != 1 || got[0] != "COVERAGE 0.2000" {
+		t.Errorf("COVERAGE with 4 of 20 pixels drawn = %q, want COVERAGE 0.2000", got)
+	}
+}
+
+func TestDominant(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+
+	// 10 reds that differ too little to count apart, 4 greens and 2 blues
+	for i := 0; i < 16; i++ {
+		color := "0000ff"
+		switch {
+		case i < 8:
+			color = "ff0000"
+		case i < 10:
+			color = "f00000"
+		case i < 14:
+			color = "00ff00"
+		}
+		c.send(fmt.Sprintf("PX %d %d %s", i%4, i/4, color))
+	}
+	c.sync()
+	g.Render()
+
+	// the reds are averaged: (8*0xff + 2*0xf0) / 10 = 0xfc
+	if got := c.do("DOMINANT"); len(got) != 1 || got[0] != "DOMINANT fc0000 0.6250" {
+		t.Errorf("DOMINANT = %q, want DOMINANT fc0000 0.6250", got)
+	}
+}
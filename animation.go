@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// animationBlock is a group of commands an animation runs at once.
+type animationBlock struct {
+	at       time.Duration // since the start of the loop
+	commands []string
+}
+
+// loadAnimation reads an animation script. A line "@<ms>" starts a block
+// run that many milliseconds after the start of the loop; the commands
+// following it, up to the next "@" line, make up the block. "@<ms> <command>"
+// is a block of a single command. A trailing "@<ms>" line without commands
+// sets how long the loop runs. Empty lines and lines starting with # are
+// ignored.
+func loadAnimation(path string) ([]animationBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var blocks []animationBlock
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !strings.HasPrefix(text, "@") {
+			if len(blocks) == 0 {
+				return nil, fmt.Errorf("%s:%d: command before the first @ line", path, line)
+			}
+			last := &blocks[len(blocks)-1]
+			last.commands = append(last.commands, text)
+			continue
+		}
+
+		at, command, _ := strings.Cut(text[1:], " ")
+		ms, err := strconv.ParseUint(at, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time %q", path, line, at)
+		}
+		block := animationBlock{at: time.Duration(ms) * time.Millisecond}
+		if len(blocks) > 0 && block.at < blocks[len(blocks)-1].at {
+			return nil, fmt.Errorf("%s:%d: time goes backwards", path, line)
+		}
+		if command = strings.TrimSpace(command); command != "" {
+			block.commands = append(block.commands, command)
+		}
+		blocks = append(blocks, block)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// runAnimation runs the animation's commands like an admin connection would
+// send them, on schedule, until shutdown, without the client rate limits.
+// Replies are discarded. Unless loop is set, it stops after the last block.
+func (g *Game) runAnimation(blocks []animationBlock, loop bool) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	state := &connState{
+		conn:      conn,
+		out:       make(chan reply, outQueueSize),
+		done:      make(chan struct{}),
+		authed:    true,
+		unlimited: true,
+		scanner:   bufio.NewScanner(strings.NewReader("")),
+	}
+	go func() {
+		for range state.out {
+		}
+	}()
+	defer func() {
+		close(state.done)
+		state.workers.Wait()
+		g.locks.unlock(state)
+		close(state.out)
+	}()
+
+	for {
+		start := g.clock.Now()
+		for _, block := range blocks {
+			select {
+			case <-g.done:
+				return
+			case <-g.clock.After(start.Add(block.at).Sub(g.clock.Now())):
+			}
+
+			for _, command := range block.commands {
+				g.handleLine(command, state)
+				if state.shutdownRequested {
+					g.shutdown()
+					return
+				}
+			}
+		}
+		if !loop {
+			return
+		}
+		if len(blocks) == 0 || blocks[len(blocks)-1].at == 0 {
+			log.Println("Animation takes no time, not looping it")
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnimation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "animation.txt")
+	script := "@0 PX 0 0 ff0000\n@100\nPX 0 0 00ff00\nPX 1 0 00ff00\n"
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := loadAnimation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestGame(t, 2, 1)
+	clock := newFakeClock()
+	g.clock = clock
+	finished := make(chan struct{})
+	go func() {
+		g.runAnimation(blocks, false)
+		close(finished)
+	}()
+
+	// the first block ran once the animation waits for the second
+	clock.waitForWaiters(t, 1)
+	if got := g.Render().RGBAAt(0, 0); got != red {
+		t.Fatalf("pixel after the first block = %v, want %v", got, red)
+	}
+
+	clock.advance(99 * time.Millisecond)
+	clock.waitForWaiters(t, 1)
+	if got := g.Render().RGBAAt(0, 0); got != red {
+		t.Errorf("pixel before the second block is due = %v, want %v", got, red)
+	}
+
+	clock.advance(time.Millisecond)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the animation didn't end after its last block")
+	}
+	canvas := g.Render()
+	if got := canvas.RGBAAt(0, 0); got != green {
+		t.Errorf("pixel after the second block = %v, want %v", got, green)
+	}
+	if got := canvas.RGBAAt(1, 0); got != green {
+		t.Errorf("second pixel of the second block = %v, want %v", got, green)
+	}
+}
+
+func TestAnimationUnlimited(t *testing.T) {
+	var script strings.Builder
+	script.WriteString("@0\n")
+	for x := 0; x < 100; x++ {
+		fmt.Fprintf(&script, "PX %d 0 ff0000\n", x)
+	}
+	path := filepath.Join(t.TempDir(), "animation.txt")
+	if err := os.WriteFile(path, []byte(script.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := loadAnimation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestGame(t, 100, 1)
+	g.cooldowns = newCooldowns(time.Second, 16)
+	g.limiters = newRateLimiters(1, 16)
+	g.runAnimation(blocks, false)
+
+	// clients are still limited
+	connect(t, g).do("PX 0 0 00ff00", "PX 1 0 00ff00")
+	canvas := g.Render()
+	for x := 2; x < 100; x++ {
+		if got := canvas.RGBAAt(x, 0); got != red {
+			t.Fatalf("animation pixel (%d, 0) = %v with -cooldown and -rate-limit, want %v", x, got, red)
+		}
+	}
+	if got := canvas.RGBAAt(1, 0); got != red {
+		t.Errorf("second client write during its cooldown drew %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// checkerSize is the edge length of the squares of the checkerboard
+// background, in canvas pixels.
+const checkerSize = 8
+
+// background is shown beneath the canvas on the display, where the canvas is
+// transparent. It is never part of the canvas itself.
+type background struct {
+	// source is stretched over the canvas; without one, a checkerboard
+	// is drawn
+	source image.Image
+
+	img  *ebiten.Image
+	size image.Point
+}
+
+// newColorBackground returns a background of the single color c.
+func newColorBackground(c color.RGBA) *background {
+	source := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	source.SetRGBA(0, 0, c)
+	return &background{source: source}
+}
+
+// loadBackground returns a background showing the image at path.
+func loadBackground(path string) (*background, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return &background{source: img}, nil
+}
+
+// draw draws the background for a canvas of the given size onto screen, with
+// the canvas placed on the screen by geoM.
+func (b *background) draw(screen *ebiten.Image, width, height int, geoM ebiten.GeoM) {
+	op := &ebiten.DrawImageOptions{}
+	if b.source == nil {
+		if b.img == nil || b.size != (image.Point{width, height}) {
+			if b.img != nil {
+				b.img.Dispose()
+			}
+			b.img = ebiten.NewImageFromImage(checkerboard(width, height))
+			b.size = image.Point{width, height}
+		}
+	} else {
+		if b.img == nil {
+			b.img = ebiten.NewImageFromImage(b.source)
+		}
+		bounds := b.img.Bounds()
+		op.GeoM.Scale(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+		op.Filter = ebiten.FilterLinear
+	}
+	op.GeoM.Concat(geoM)
+	screen.DrawImage(b.img, op)
+}
+
+// at returns the premultiplied background color at (x, y) of a canvas of
+// the given size, for displays that are composited pixel by pixel. A source
+// image is sampled at the nearest pixel rather than filtered.
+func (b *background) at(x, y, width, height int) color.RGBA {
+	if b.source == nil {
+		return checkerColor(x, y)
+	}
+	bounds := b.source.Bounds()
+	sx := bounds.Min.X + x*bounds.Dx()/width
+	sy := bounds.Min.Y + y*bounds.Dy()/height
+	return color.RGBAModel.Convert(b.source.At(sx, sy)).(color.RGBA)
+}
+
+// checkerboard returns a light and dark gray checkerboard of the given size.
+func checkerboard(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, checkerColor(x, y))
+		}
+	}
+	return img
+}
+
+// checkerColor returns the color of the checkerboard at (x, y).
+func checkerColor(x, y int) color.RGBA {
+	v := uint8(0x66)
+	if (x/checkerSize+y/checkerSize)%2 == 0 {
+		v = 0x99
+	}
+	return color.RGBA{v, v, v, 255}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// backlogPolicy decides what happens to a pixel write while its shard of
+// pixelUpdates is full, i.e. while the display lags behind by a whole queue
+// of updates.
+type backlogPolicy int
+
+const (
+	// backlogBlock makes the writer wait for room. Nothing is lost, but
+	// the display lags and flooding clients are slowed down.
+	backlogBlock backlogPolicy = iota
+	// backlogDropOldest discards the oldest queued update to make room,
+	// keeping the display close to what clients are sending right now.
+	backlogDropOldest
+	// backlogDropNewest discards the incoming write, keeping latency low
+	// for what is already queued.
+	backlogDropNewest
+)
+
+func parseBacklogPolicy(s string) (backlogPolicy, bool) {
+	switch s {
+	case "block":
+		return backlogBlock, true
+	case "drop-oldest":
+		return backlogDropOldest, true
+	case "drop-newest":
+		return backlogDropNewest, true
+	}
+	return 0, false
+}
+
+// enqueue queues a pixel update for the render goroutine according to the
+// backlog policy. Updates in a priority region always wait for room instead
+// of being dropped. After shutdown, updates are dropped.
+func (g *Game) enqueue(update PixelUpdate) {
+	policy := g.backlogPolicy
+	if policy != backlogBlock && g.priority.contains(image.Point{int(update.x), int(update.y)}) {
+		policy = backlogBlock
+	}
+
+	if !g.queueFor(update).push(update, policy) {
+		g.stats.dropped.Add(1)
+	}
+}
+
+// pixelQueue is a bounded FIFO of pixel updates with a single consumer, the
+// render goroutine. Unlike a channel it is a ring buffer the writers may
+// overwrite the oldest entry of, which drop-oldest needs, and the consumer
+// takes all entries at once by swapping in a second buffer.
+type pixelQueue struct {
+	mu      sync.Mutex
+	notFull *sync.Cond
+	buf     []PixelUpdate
+	spare   []PixelUpdate
+	head, n int
+	closed  bool
+	// size is the capacity of buf and spare, which drain swaps, so it can
+	// be read without the lock
+	size int
+}
+
+func newPixelQueue(size int) *pixelQueue {
+	q := &pixelQueue{
+		size:  size,
+		buf:   make([]PixelUpdate, size),
+		spare: make([]PixelUpdate, size),
+	}
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push appends update, applying policy if the queue is full. It reports
+// whether update was queued without dropping one; a closed queue drops
+// everything.
+func (q *pixelQueue) push(update PixelUpdate, policy backlogPolicy) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.n == len(q.buf) {
+		switch policy {
+		case backlogDropNewest:
+			return false
+		case backlogDropOldest:
+			if q.closed {
+				return false
+			}
+			q.buf[q.head] = update
+			q.head = (q.head + 1) % len(q.buf)
+			return false
+		default:
+			for q.n == len(q.buf) && !q.closed {
+				q.notFull.Wait()
+			}
+		}
+	}
+	if q.closed {
+		return false
+	}
+	q.buf[(q.head+q.n)%len(q.buf)] = update
+	q.n++
+	return true
+}
+
+// drain takes all queued updates and calls apply for each of them in order,
+// returning how many there were. apply runs without holding the queue, so
+// writers can refill it meanwhile. Only one goroutine may drain a queue.
+func (q *pixelQueue) drain(apply func(PixelUpdate)) int {
+	q.mu.Lock()
+	buf, head, n := q.buf, q.head, q.n
+	q.buf, q.spare = q.spare, q.buf
+	q.head, q.n = 0, 0
+	if n == len(buf) {
+		q.notFull.Broadcast()
+	}
+	q.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		apply(buf[(head+i)%len(buf)])
+	}
+	return n
+}
+
+// close makes the queue drop further updates and wakes up blocked writers.
+func (q *pixelQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notFull.Broadcast()
+}
+
+// len returns the number of queued updates.
+func (q *pixelQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.n
+}
+
+// queueTile is the edge length of the square canvas tiles that share a
+// shard of pixelUpdates.
+//
+// Writers never lock the canvas itself: only the render goroutine draws into
+// it, holding canvasMu once per frame. What writers do contend on is the
+// mutex of their queue, so each shard is in effect the lock of the tiles
+// mapped to it. Writers drawing in different areas then rarely contend, while
+// all updates of one pixel stay in order.
+const queueTile = 64
+
+// queueFor returns the shard of pixelUpdates that takes update.
+//
+// A pixel always maps to the same shard, and a connection enqueues its
+// writes one after another, so writes of one connection to the same pixel are
+// drawn in the order they were sent and the last one wins. Only the
+// drop-newest policy and the flicker guard may discard the later one. Writes
+// of different connections are ordered by whoever reaches the shard first,
+// which is unspecified. Commands drawn by render tasks, like COPY or FLUSH,
+// keep their place too: applyPending draws them after the writes queued
+// before them, and the connection's next write waits until they ran.
+func (g *Game) queueFor(update PixelUpdate) *pixelQueue {
+	if len(g.pixelUpdates) == 1 {
+		return g.pixelUpdates[0]
+	}
+	tx, ty := uint32(update.x)/queueTile, uint32(update.y)/queueTile
+	return g.pixelUpdates[(tx*73856093^ty*19349663)%uint32(len(g.pixelUpdates))]
+}
+
+// newPixelQueues returns shards pixel update queues holding size updates in
+// total.
+func newPixelQueues(size, shards int) []*pixelQueue {
+	queues := make([]*pixelQueue, shards)
+	for i := range queues {
+		queues[i] = newPixelQueue(max(size/shards, 1))
+	}
+	return queues
+}
+
+// queuedUpdates returns the number of pixel updates waiting for the next
+// frame.
+func (g *Game) queuedUpdates() int {
+	n := 0
+	for _, queue := range g.pixelUpdates {
+		n += queue.len()
+	}
+	return n
+}
+
+// queueCapacity returns the number of pixel updates the queues hold in
+// total.
+func (g *Game) queueCapacity() int {
+	n := 0
+	for _, queue := range g.pixelUpdates {
+		n += queue.size
+	}
+	return n
+}
+
+// queueLatency estimates how long a pixel written now waits before it is
+// drawn, from the queued updates and the recent pixel rate. Without a
+// measured rate it is zero.
+func (g *Game) queueLatency() time.Duration {
+	rate := g.stats.pixelsPerSec()
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(g.queuedUpdates()) / rate * float64(time.Second))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// queued returns the x coordinates of the updates in q, draining it.
+func queued(q *pixelQueue) []int32 {
+	var xs []int32
+	q.drain(func(update PixelUpdate) { xs = append(xs, update.x) })
+	return xs
+}
+
+func TestBacklogPolicies(t *testing.T) {
+	tests := []struct {
+		policy  backlogPolicy
+		kept    []int32
+		dropped int
+	}{
+		// drop-newest keeps what came first, the display lags behind
+		{backlogDropNewest, []int32{0, 1, 2, 3}, 6},
+		// drop-oldest keeps the latest writes
+		{backlogDropOldest, []int32{6, 7, 8, 9}, 6},
+	}
+	for _, test := range tests {
+		q := newPixelQueue(4)
+		dropped := 0
+		for x := int32(0); x < 10; x++ {
+			if !q.push(PixelUpdate{x: x}, test.policy) {
+				dropped++
+			}
+		}
+		if got := queued(q); fmt.Sprint(got) != fmt.Sprint(test.kept) {
+			t.Errorf("policy %d kept %v, want %v", test.policy, got, test.kept)
+		}
+		if dropped != test.dropped {
+			t.Errorf("policy %d dropped %d, want %d", test.policy, dropped, test.dropped)
+		}
+	}
+}
+
+func TestBacklogBlock(t *testing.T) {
+	q := newPixelQueue(4)
+	pushed := make(chan struct{})
+	go func() {
+		for x := int32(0); x < 10; x++ {
+			q.push(PixelUpdate{x: x}, backlogBlock)
+		}
+		close(pushed)
+	}()
+
+	// nothing is lost, the writer waits for the queue to be drained
+	var got []int32
+	deadline := time.Now().Add(5 * time.Second)
+	for len(got) < 10 && time.Now().Before(deadline) {
+		got = append(got, queued(q)...)
+		time.Sleep(time.Millisecond)
+	}
+	<-pushed
+	if fmt.Sprint(got) != fmt.Sprint([]int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("blocking queue delivered %v", got)
+	}
+}
+
+func TestBacklogBlockShutdown(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.pixelUpdates = newPixelQueues(1, 1)
+	c := connect(t, g)
+
+	// the second write waits for room until shutdown releases it
+	c.send("PX 1 1 ff0000", "PX 2 2 ff0000")
+	time.Sleep(10 * time.Millisecond)
+	g.shutdown()
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("connection still open after shutdown")
+	}
+	if g.pixelUpdates[0].push(PixelUpdate{}, backlogBlock) {
+		t.Error("a closed queue accepted an update")
+	}
+}
+
+// BenchmarkBacklogPolicy floods a small queue faster than it is drained and
+// reports, per policy, the share of writes lost and how many writes behind
+// the writer the drained ones were on average.
+func BenchmarkBacklogPolicy(b *testing.B) {
+	for _, policy := range []struct {
+		name   string
+		policy backlogPolicy
+	}{
+		{"block", backlogBlock},
+		{"drop-oldest", backlogDropOldest},
+		{"drop-newest", backlogDropNewest},
+	} {
+		b.Run(policy.name, func(b *testing.B) {
+			q := newPixelQueue(1024)
+			var written atomic.Int64
+			var drained, lag int64
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				apply := func(update PixelUpdate) {
+					drained++
+					lag += written.Load() - int64(update.x)
+				}
+				for {
+					select {
+					case <-done:
+						q.drain(apply)
+						return
+					case <-time.After(100 * time.Microsecond):
+					}
+					q.drain(apply)
+				}
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.push(PixelUpdate{x: int32(i)}, policy.policy)
+				written.Store(int64(i))
+				if i%64 == 0 {
+					// leave the renderer a chance even on a single CPU
+					runtime.Gosched()
+				}
+			}
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+
+			b.ReportMetric(1-float64(drained)/float64(b.N), "lost/write")
+			if drained > 0 {
+				b.ReportMetric(float64(lag)/float64(drained), "lag-writes")
+			}
+		})
+	}
+}
+
+func TestPriorityRegionSurvivesFullQueue(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.pixelUpdates = newPixelQueues(1, 1)
+	g.backlogPolicy = backlogDropNewest
+	c := connect(t, g)
+	c.do("AUTH secret", "PRIORITY 5 5 2 2")
+
+	// the queue is full after the first write, so the second one is
+	// dropped while the priority write waits for room
+	c.do("PX 1 1 ff0000", "PX 2 2 ff0000")
+	c.send("PX 5 5 00ff00")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		canvas := g.Render()
+		if canvas.RGBAAt(5, 5) == green {
+			if got := canvas.RGBAAt(1, 1); got != red {
+				t.Errorf("first write = %v, want %v", got, red)
+			}
+			if got := canvas.RGBAAt(2, 2); got != (color.RGBA{}) {
+				t.Errorf("write to a full queue = %v, want it dropped", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the priority write never arrived")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueCommand(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	const n = 150
+	for i := 0; i < n; i++ {
+		c.send(fmt.Sprintf("PX %d %d ff0000", i%20, i/20))
+	}
+	got := c.do("QUEUE")
+	want := fmt.Sprintf("QUEUE %d %d 0", n, g.queueCapacity())
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("QUEUE with %d writes waiting = %q, want %q", n, got, want)
+	}
+
+	// the frame may have measured a pixel rate, which the latency depends on
+	g.Render()
+	want = fmt.Sprintf("QUEUE 0 %d ", g.queueCapacity())
+	if got := c.do("QUEUE"); len(got) != 1 || !strings.HasPrefix(got[0], want) {
+		t.Errorf("QUEUE after a frame = %q, want %q", got, want)
+	}
+}
+
+func TestQueueDuringFrames(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	// drain swaps the queue's buffers while QUEUE and CHANSTATS read them
+	stop := make(chan struct{})
+	rendered := make(chan struct{})
+	go func() {
+		defer close(rendered)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			g.Render()
+		}
+	}()
+	c.do("AUTH secret")
+	for i := 0; i < 100; i++ {
+		c.do("PX 1 1 ff0000", "QUEUE", "CHANSTATS")
+	}
+	close(stop)
+	<-rendered
+}
+
+func TestWriteOrder(t *testing.T) {
+	for _, commands := range [][]string{
+		{"PX 1 1 ff0000", "PX 1 1 0000ff"},
+		{"PX 1 1 ff0000", "HOLD", "PX 1 1 0000ff", "FLUSH"},
+		{"HOLD", "PX 1 1 ff0000", "FLUSH", "PX 1 1 0000ff"},
+		{"PX 1 1 ff0000", "DISC 1 1 1 0000ff"},
+		{"PX 1 1 ff0000", "PXFADE 1 1 0000ff 1"},
+		{"PXFADE 1 1 ff0000 1", "PX 1 1 0000ff"},
+		{"AUTH secret", "PX 1 1 ff0000", "PX 0 0 0000ff", "COPY 0 0 1 1 1 1"},
+		{"AUTH secret", "PX 0 0 ff0000", "COPY 0 0 1 1 1 1", "PX 1 1 0000ff"},
+	} {
+		t.Run(strings.Join(commands, ", "), func(t *testing.T) {
+			g := newTestGame(t, 200, 200)
+			g.pixelUpdates = newPixelQueues(1024, 4)
+			c := connect(t, g)
+
+			// render like the window would, so commands waiting for a
+			// frame get one
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(time.Millisecond):
+					}
+					g.Render()
+				}
+			}()
+			c.do(commands...)
+			close(stop)
+			wg.Wait()
+
+			// the fade needs a frame to reach its color
+			g.Render()
+			if got := g.Render().RGBAAt(1, 1); got != blue {
+				t.Errorf("pixel = %v, want the last write %v", got, blue)
+			}
+		})
+	}
+}
+
+func BenchmarkQueueShards(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := newTestGame(b, 1024, 1024)
+			g.pixelUpdates = newPixelQueues(1<<16, shards)
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(100 * time.Microsecond):
+					}
+					g.applyPending()
+				}
+			}()
+
+			// every writer draws in a tile of its own
+			var writers atomic.Int32
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := writers.Add(1)
+				origin := PixelUpdate{x: n % 16 * queueTile, y: n / 16 % 16 * queueTile, color: red}
+				for i := 0; pb.Next(); i++ {
+					update := origin
+					update.x += int32(i % queueTile)
+					g.enqueue(update)
+				}
+			})
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+		})
+	}
+}
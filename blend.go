@@ -0,0 +1,58 @@
+package main
+
+import "image/color"
+
+// blendMode selects how a pixel write is combined with the pixel already on
+// the canvas.
+type blendMode uint8
+
+const (
+	// blendNormal composites the new color over the old one.
+	blendNormal blendMode = iota
+	// blendAdd adds the colors, for light painting.
+	blendAdd
+	// blendMultiply multiplies the colors, darkening the canvas.
+	blendMultiply
+	// blendScreen inverts, multiplies and inverts again, lightening the
+	// canvas.
+	blendScreen
+	// blendMax keeps the brighter of both colors per channel.
+	blendMax
+	// blendReplace replaces the pixel, alpha included, for REGIONSET. BLEND
+	// can't select it.
+	blendReplace
+)
+
+var blendModes = map[string]blendMode{
+	"normal":   blendNormal,
+	"add":      blendAdd,
+	"multiply": blendMultiply,
+	"screen":   blendScreen,
+	"max":      blendMax,
+}
+
+// blendPixel combines the premultiplied color c with the premultiplied
+// pixel p using one of the separable modes other than blendNormal. Where
+// either color is translucent, the rest of the other one shows through like
+// with alpha-over.
+func blendPixel(p []uint8, c color.RGBA, mode blendMode) {
+	sa, da := uint32(c.A), uint32(p[3])
+	src := [3]uint32{uint32(c.R), uint32(c.G), uint32(c.B)}
+
+	for i, s := range src {
+		d := uint32(p[i])
+		var v uint32
+		switch mode {
+		case blendAdd:
+			v = s + d
+		case blendMultiply:
+			v = (s*d + s*(255-da) + d*(255-sa)) / 255
+		case blendScreen:
+			v = s + d - s*d/255
+		case blendMax:
+			v = (max(s*da, d*sa) + s*(255-da) + d*(255-sa)) / 255
+		}
+		p[i] = uint8(min(v, 255))
+	}
+	p[3] = uint8(sa + da - sa*da/255)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestBlendModes(t *testing.T) {
+	g := newTestGame(t, 4, 1)
+	c := connect(t, g)
+	c.do("PX 0 0 808080", "PX 1 0 808080", "PX 2 0 ff8000", "PX 3 0 808080")
+	g.Render()
+
+	c.do("BLEND add", "PX 0 0 406080", "PX 2 0 ff8000")
+	c.do("BLEND multiply", "PX 1 0 808080")
+	// BLEND normal goes back to alpha-over
+	c.do("BLEND normal", "PX 3 0 ff000080")
+	canvas := g.Render()
+
+	want := []color.RGBA{
+		{0xc0, 0xe0, 0xff, 0xff},
+		{0x40, 0x40, 0x40, 0xff},
+		// saturated
+		{0xff, 0xff, 0x00, 0xff},
+		{0xbf, 0x3f, 0x3f, 0xff},
+	}
+	for x, want := range want {
+		if got := canvas.RGBAAt(x, 0); got != want {
+			t.Errorf("pixel %d = %v, want %v", x, got, want)
+		}
+	}
+
+	if got := c.do("BLEND burn"); len(got) != 1 || got[0] != "ERROR unknown blend mode" {
+		t.Errorf("BLEND burn replied %q", got)
+	}
+}
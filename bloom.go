@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/colorm"
+)
+
+const (
+	// bloomThreshold is the brightness above which pixels start to glow.
+	bloomThreshold = 0.6
+	// bloomDownscale is the factor by which the glow is computed at a lower
+	// resolution, which both widens and cheapens the blur.
+	bloomDownscale = 4
+)
+
+// bloomWeights is a 5 tap binomial approximation of a gaussian kernel.
+var bloomWeights = [...]float32{1.0 / 16, 4.0 / 16, 6.0 / 16, 4.0 / 16, 1.0 / 16}
+
+// bloom draws a soft glow around the bright pixels of a frame. It only ever
+// draws onto the screen, so the canvas and everything read from it stay
+// crisp.
+type bloom struct {
+	intensity float32
+
+	bright, blurX, blurY *ebiten.Image
+}
+
+// draw composites the glow of frame onto screen, with frame placed on the
+// screen by geoM.
+func (b *bloom) draw(screen, frame *ebiten.Image, geoM ebiten.GeoM) {
+	width := frame.Bounds().Dx()/bloomDownscale + 1
+	height := frame.Bounds().Dy()/bloomDownscale + 1
+	if b.bright == nil || b.bright.Bounds().Dx() != width || b.bright.Bounds().Dy() != height {
+		for _, img := range []*ebiten.Image{b.bright, b.blurX, b.blurY} {
+			if img != nil {
+				img.Dispose()
+			}
+		}
+		b.bright = ebiten.NewImage(width, height)
+		b.blurX = ebiten.NewImage(width, height)
+		b.blurY = ebiten.NewImage(width, height)
+	}
+
+	// bright pass at reduced resolution: keep only what exceeds the threshold
+	var cm colorm.ColorM
+	cm.Translate(-bloomThreshold, -bloomThreshold, -bloomThreshold, 0)
+	cm.Scale(1/(1-bloomThreshold), 1/(1-bloomThreshold), 1/(1-bloomThreshold), 1)
+	brightOp := &colorm.DrawImageOptions{Filter: ebiten.FilterLinear}
+	brightOp.GeoM.Scale(1.0/bloomDownscale, 1.0/bloomDownscale)
+	b.bright.Clear()
+	colorm.DrawImage(b.bright, frame, cm, brightOp)
+
+	// separable blur, horizontal then vertical
+	b.blurX.Clear()
+	b.blurY.Clear()
+	for i, weight := range bloomWeights {
+		offset := float64(i - len(bloomWeights)/2)
+
+		op := &ebiten.DrawImageOptions{Blend: ebiten.BlendLighter}
+		op.GeoM.Translate(offset, 0)
+		op.ColorScale.Scale(weight, weight, weight, weight)
+		b.blurX.DrawImage(b.bright, op)
+	}
+	for i, weight := range bloomWeights {
+		offset := float64(i - len(bloomWeights)/2)
+
+		op := &ebiten.DrawImageOptions{Blend: ebiten.BlendLighter}
+		op.GeoM.Translate(0, offset)
+		op.ColorScale.Scale(weight, weight, weight, weight)
+		b.blurY.DrawImage(b.blurX, op)
+	}
+
+	op := &ebiten.DrawImageOptions{Blend: ebiten.BlendLighter, Filter: ebiten.FilterLinear}
+	op.GeoM.Scale(bloomDownscale, bloomDownscale)
+	op.GeoM.Concat(geoM)
+	op.ColorScale.Scale(b.intensity, b.intensity, b.intensity, b.intensity)
+	screen.DrawImage(b.blurY, op)
+}
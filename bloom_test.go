@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func TestBloomLeavesCanvasCrisp(t *testing.T) {
+	g := newTestGame(t, 16, 16)
+	g.bloom = &bloom{intensity: 0.8}
+	c := connect(t, g)
+
+	c.do("PX 8 8 ffffff", "PX 9 8 808080")
+	want := g.Render()
+
+	screen := ebiten.NewImage(16, 16)
+	for i := 0; i < 2; i++ {
+		g.Draw(screen)
+	}
+	if got := g.Snapshot(); !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("drawing with bloom changed the canvas")
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// maxCanvasSize bounds each canvas dimension accepted by RESIZE.
+const maxCanvasSize = 8192
+
+// size returns the current canvas dimensions.
+func (g *Game) size() (width, height int) {
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+	return g.canvas.Rect.Dx(), g.canvas.Rect.Dy()
+}
+
+// storeCanvasSize updates canvasSize to the size of r.
+func (g *Game) storeCanvasSize(r image.Rectangle) {
+	g.canvasSize.Store(uint64(r.Dx())<<32 | uint64(r.Dy()))
+}
+
+// inCanvas reports whether (x, y) lies on the canvas. A RESIZE may still
+// shrink the canvas before a checked pixel is drawn, which setPixel handles.
+func (g *Game) inCanvas(x, y int) bool {
+	size := g.canvasSize.Load()
+	return x >= 0 && y >= 0 && x < int(size>>32) && y < int(size&0xffffffff)
+}
+
+// applyPending drains the pixel updates that were queued when it was called
+// into the canvas, starts the fades sent by then and then runs the render
+// tasks queued by then. It must only
+// be called on the render goroutine.
+//
+// Queued writes go first, so a command drawn by a render task lands on top
+// of the writes its connection sent before it. Writes sent after it wait for
+// the task, see queueFor.
+func (g *Game) applyPending() {
+	g.canvasMu.Lock()
+	defer g.canvasMu.Unlock()
+
+	// counted first, tasks queued while draining belong to the next frame
+	tasks := len(g.renderTasks)
+
+	// everything drawn below belongs to the same frame
+	if g.changes != nil {
+		g.changes.nextFrame()
+	}
+	if g.flicker != nil {
+		g.flicker.nextFrame()
+	}
+	scheduled := g.nextFrame()
+	for _, update := range scheduled {
+		g.applyUpdate(update)
+	}
+	g.stats.pixels.Add(uint64(len(scheduled)))
+
+	g.queueDepths.sample(g.queuedUpdates())
+	for _, shard := range g.pixelUpdates {
+		n := shard.drain(func(update PixelUpdate) { g.applyUpdate(update) })
+		g.stats.pixels.Add(uint64(n))
+	}
+	g.startFades()
+
+	for ; tasks > 0; tasks-- {
+		task := <-g.renderTasks
+		task()
+	}
+	if len(g.fades) != 0 {
+		g.stepFades()
+	}
+}
+
+// Render synchronously applies all pending updates and returns a copy of the
+// resulting canvas. It stands in for Draw when no window is running, so it
+// must not be called while ebiten is driving the game.
+func (g *Game) Render() *image.RGBA {
+	g.applyPending()
+	return g.Snapshot()
+}
+
+// Snapshot returns a copy of the canvas as it was last drawn. Pixel updates
+// that are still queued are not included. It is safe to call from any
+// goroutine.
+func (g *Game) Snapshot() *image.RGBA {
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+
+	snapshot := image.NewRGBA(g.canvas.Rect)
+	copy(snapshot.Pix, g.canvas.Pix)
+	return snapshot
+}
+
+// runHeadless drives the canvas at the usual frame rate without a window,
+// pushing each frame to the display if one is attached.
+func (g *Game) runHeadless() {
+	ticker := time.NewTicker(time.Second / 60)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+		}
+		g.headlessFrame()
+	}
+}
+
+// headlessFrame renders a frame for runHeadless.
+func (g *Game) headlessFrame() {
+	if !g.paused.Load() {
+		g.applyPending()
+	}
+	if g.display != nil {
+		// the canvas is only written on this goroutine
+		g.updateDisplay(g.dirty)
+	}
+	g.dirty = image.Rectangle{}
+	g.stats.samplePixelRate(time.Now())
+}
+
+// queueTask queues task to run on the render goroutine. Tasks run while
+// canvasMu is held for writing. After shutdown, tasks are dropped.
+func (g *Game) queueTask(task func()) {
+	select {
+	case g.renderTasks <- task:
+	case <-g.done:
+	}
+}
+
+// fence queues an empty render task and returns a channel that is closed once
+// it ran, after every task queued before it. On shutdown it is never closed.
+func (g *Game) fence() <-chan struct{} {
+	ran := make(chan struct{})
+	g.queueTask(func() { close(ran) })
+	return ran
+}
+
+// applyUpdate draws a pixel update, unless the pixel is protected or held
+// by the flicker guard, ends a fade of the pixel and records its writer.
+// The caller must hold canvasMu for writing. It reports whether the update
+// was drawn.
+func (g *Game) applyUpdate(update PixelUpdate) bool {
+	x, y := int(update.x), int(update.y)
+	// checked again here, the region may have been protected while the
+	// update was queued or held
+	if g.protected.contains(image.Point{x, y}) {
+		g.stats.dropped.Add(1)
+		return false
+	}
+	if g.flicker != nil && !g.flicker.allow(x, y) {
+		g.stats.dropped.Add(1)
+		return false
+	}
+	if len(g.fades) != 0 {
+		// a write ends any fade of the pixel
+		delete(g.fades, image.Point{x, y})
+	}
+	g.setPixel(x, y, update.color, update.blend)
+	if g.owners != nil {
+		g.owners.record(x, y, update.owner)
+	}
+	return true
+}
+
+// setPixel combines the premultiplied color c with the canvas pixel at
+// (x, y) using mode. Coordinates outside the canvas are ignored. The caller
+// must hold canvasMu for writing.
+func (g *Game) setPixel(x, y int, c color.RGBA, mode blendMode) {
+	if !(image.Point{x, y}.In(g.canvas.Rect)) {
+		return
+	}
+
+	g.markDirty(image.Rect(x, y, x+1, y+1))
+	i := g.canvas.PixOffset(x, y)
+	p := g.canvas.Pix[i : i+4 : i+4]
+	if mode == blendReplace {
+		p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		return
+	}
+	if mode != blendNormal {
+		blendPixel(p, c, mode)
+		return
+	}
+	if c.A == 255 {
+		p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		return
+	}
+	if g.linearBlend {
+		blendLinear(p, c)
+		return
+	}
+
+	inv := 255 - uint32(c.A)
+	p[0] = uint8(uint32(c.R) + uint32(p[0])*inv/255)
+	p[1] = uint8(uint32(c.G) + uint32(p[1])*inv/255)
+	p[2] = uint8(uint32(c.B) + uint32(p[2])*inv/255)
+	p[3] = uint8(uint32(c.A) + uint32(p[3])*inv/255)
+}
+
+// compareAndSet writes update if the canvas pixel at its position currently
+// has the color of expected, ignoring alpha like PX reads do, and reports
+// whether it did. The check and the write happen together on the render
+// goroutine, so no other write can slip in between; pixel updates that are
+// still queued are applied after it. It waits for the next frame and returns
+// false on shutdown.
+func (g *Game) compareAndSet(update PixelUpdate, expected color.RGBA) bool {
+	result := make(chan bool, 1)
+	g.queueTask(func() {
+		at := image.Point{int(update.x), int(update.y)}
+		if !at.In(g.canvas.Rect) {
+			result <- false
+			return
+		}
+		current := g.canvas.RGBAAt(at.X, at.Y)
+		if current.R != expected.R || current.G != expected.G || current.B != expected.B {
+			result <- false
+			return
+		}
+		result <- g.applyUpdate(update)
+	})
+
+	select {
+	case ok := <-result:
+		return ok
+	case <-g.done:
+		return false
+	}
+}
+
+// markDirty records that the canvas changed within r since the last upload
+// and forwards r to the mirror. It must only be called on the render
+// goroutine.
+func (g *Game) markDirty(r image.Rectangle) {
+	g.dirty = g.dirty.Union(r)
+	if g.changes != nil {
+		g.changes.mark(r)
+	}
+	if g.mirror != nil {
+		g.mirror.forward(r)
+	}
+}
+
+// updateWindow pushes the canvas pixels changed since the last update to
+// the window, recreating its display first if the canvas size changed. It
+// must only be called on the render goroutine.
+func (g *Game) updateWindow() {
+	if g.window.fit(g.canvas.Rect) {
+		g.dirty = g.canvas.Rect
+	}
+	g.updateDisplay(g.dirty)
+	g.dirty = image.Rectangle{}
+}
+
+// copyRegion queues a render task copying the canvas pixels in src to the
+// rectangle of the same size at dst. Both are clipped to the canvas, and the
+// regions may overlap.
+func (g *Game) copyRegion(src image.Rectangle, dst image.Point) {
+	g.queueTask(func() {
+		// clip the source, moving the destination along
+		clipped := src.Intersect(g.canvas.Rect)
+		if clipped.Empty() {
+			return
+		}
+		dst := dst.Add(clipped.Min.Sub(src.Min))
+
+		// copy through a buffer, so overlapping regions don't smear
+		buf := image.NewRGBA(clipped)
+		draw.Draw(buf, clipped, g.canvas, clipped.Min, draw.Src)
+
+		target := clipped.Sub(clipped.Min).Add(dst).Intersect(g.canvas.Rect)
+		draw.Draw(g.canvas, target, buf, clipped.Min.Add(target.Min.Sub(dst)), draw.Src)
+		g.markDirty(target)
+	})
+}
+
+// clear fills the whole canvas with opaque black.
+func (g *Game) clear() {
+	g.canvasMu.Lock()
+	defer g.canvasMu.Unlock()
+
+	draw.Draw(g.canvas, g.canvas.Rect, image.Black, image.Point{}, draw.Src)
+	g.markDirty(g.canvas.Rect)
+	if g.owners != nil {
+		g.owners.reset()
+	}
+}
+
+// resize queues a render task that reallocates the canvas, keeping the
+// pixels in the rectangle shared by the old and new sizes.
+func (g *Game) resize(width, height int) {
+	g.queueTask(func() {
+		resized := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(resized, g.canvas.Rect, g.canvas, image.Point{}, draw.Src)
+		g.canvas = resized
+		g.storeCanvasSize(resized.Rect)
+		if g.changes != nil {
+			g.changes.resize(resized.Rect)
+		}
+		g.markDirty(resized.Rect)
+		if g.owners != nil {
+			g.owners.resize(resized.Rect)
+		}
+		if g.flicker != nil {
+			g.flicker.resize(resized.Rect)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestResizeKeepsOverlap(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("PX 2 2 ff0000", "PX 8 8 00ff00")
+	g.Render()
+	c.do("AUTH secret", "RESIZE 5 4")
+	g.Render()
+
+	if width, height := g.size(); width != 5 || height != 4 {
+		t.Fatalf("size after RESIZE = %dx%d, want 5x4", width, height)
+	}
+	if got := g.pixel(2, 2); got != red {
+		t.Errorf("pixel in the overlap = %v, want %v", got, red)
+	}
+	if got := c.do("SIZE"); len(got) != 1 || got[0] != "SIZE 5 4" {
+		t.Errorf("SIZE replied %q", got)
+	}
+}
+
+func TestRenderOverlappingAlpha(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+
+	c.do("PX 0 0 000000", "PX 0 0 ff000080", "PX 0 0 00ff0080", "PX 0 0 0000ff80")
+	got := g.Render().RGBAAt(0, 0)
+
+	// each half transparent pixel is blended over the previous ones
+	want := color.RGBA{31, 63, 128, 255}
+	if got != want {
+		t.Errorf("pixel after three alpha writes = %v, want %v", got, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	g := newTestGame(t, 3, 3)
+	c := connect(t, g)
+
+	c.do("PX 0 0 ff0000", "PX 2 1 0000ff")
+	g.Render()
+	snapshot := g.Snapshot()
+	if got := snapshot.RGBAAt(0, 0); got != red {
+		t.Errorf("snapshot pixel (0,0) = %v, want %v", got, red)
+	}
+	if got := snapshot.RGBAAt(2, 1); got != blue {
+		t.Errorf("snapshot pixel (2,1) = %v, want %v", got, blue)
+	}
+
+	// queued writes aren't in it, and it doesn't change with the canvas
+	c.do("PX 1 1 00ff00")
+	if got := g.Snapshot().RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("queued write in the snapshot: %v", got)
+	}
+	snapshot.SetRGBA(0, 0, white)
+	g.Render()
+	if got := snapshot.RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("snapshot changed with the canvas: %v", got)
+	}
+	if got := g.Snapshot().RGBAAt(0, 0); got != red {
+		t.Errorf("changing a snapshot changed the canvas: %v", got)
+	}
+}
+
+func TestSnapshotWhileRendering(t *testing.T) {
+	g := newTestGame(t, 16, 16)
+	c := connect(t, g)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			g.Snapshot()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.send(fmt.Sprintf("PX %d %d ff0000", i%16, i/16))
+		g.Render()
+	}
+	<-done
+}
+
+func TestCompareAndSet(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+	c.do("PX 1 1 ff0000")
+	g.Render()
+
+	do := func(line string) []string {
+		c.send(line)
+		renderTask(t, g)
+		return c.sync()
+	}
+	if got := do("PXCAS 1 1 ff0000 00ff00"); len(got) != 1 || got[0] != "PXCAS 1 1 OK" {
+		t.Errorf("CAS with the current color replied %q", got)
+	}
+	if got := do("PXCAS 1 1 ff0000 0000ff"); len(got) != 1 || got[0] != "PXCAS 1 1 FAIL" {
+		t.Errorf("CAS with a stale color replied %q", got)
+	}
+	if got := g.Snapshot().RGBAAt(1, 1); got != green {
+		t.Errorf("pixel after both CAS = %v, want %v", got, green)
+	}
+}
+
+func TestCompareAndSetFlicker(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.flicker = newFlickerGuard(10, g.canvas.Rect)
+	c := connect(t, g)
+	c.do("PX 1 1 ff0000")
+	g.Render()
+
+	// the flicker guard drops the write, so the CAS must fail
+	c.send("PXCAS 1 1 ff0000 00ff00")
+	renderTask(t, g)
+	if got := c.sync(); len(got) != 1 || got[0] != "PXCAS 1 1 FAIL" {
+		t.Errorf("CAS dropped by the flicker guard replied %q", got)
+	}
+}
+
+func TestCompareAndSetAdmission(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+	c.do("AUTH secret", "PROTECT 0 0 2 2")
+
+	// rejected before it gets to the render goroutine
+	if got := c.do("PXCAS 1 1 000000 00ff00"); len(got) != 1 || got[0] != "PXCAS 1 1 FAIL" {
+		t.Errorf("CAS in a protected region replied %q", got)
+	}
+	if got := g.Render().RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("protected pixel = %v, want untouched", got)
+	}
+}
+
+// renderTask renders a frame once a render task is queued, for commands that
+// wait for the frame applying them.
+func renderTask(t *testing.T, g *Game) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(g.renderTasks) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no render task was queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	g.Render()
+}
+
+// BenchmarkUpdateWindow compares uploading only the dirty rows with
+// uploading the whole canvas, for a frame in which a few pixels of a small
+// area changed.
+func BenchmarkUpdateWindow(b *testing.B) {
+	for _, full := range []bool{false, true} {
+		name := "dirty-rect"
+		if full {
+			name = "full-blit"
+		}
+		b.Run(name, func(b *testing.B) {
+			g := newTestGame(b, 1920, 1080)
+			g.window = &windowDisplay{}
+			g.display = g.window
+			g.updateWindow()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 10; j++ {
+					g.setPixel(900+j*3, 500+j, red, blendNormal)
+				}
+				if full {
+					g.markDirty(g.canvas.Rect)
+				}
+				g.updateWindow()
+			}
+		})
+	}
+}
+
+func TestCopyRegion(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+	c.do("AUTH secret", "PX 0 0 ff0000", "PX 1 0 00ff00")
+	g.Render()
+
+	check := func(name string, pixels map[image.Point]color.RGBA) {
+		t.Helper()
+		canvas := g.Render()
+		for p, want := range pixels {
+			if got := canvas.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", name, p, got, want)
+			}
+		}
+	}
+	c.do("COPY 0 0 2 1 2 2")
+	check("copy", map[image.Point]color.RGBA{
+		{0, 0}: red, {1, 0}: green, {2, 2}: red, {3, 2}: green,
+	})
+	// overlapping the source, which is read before it's overwritten
+	c.do("COPY 0 0 2 1 1 0")
+	check("overlapping copy", map[image.Point]color.RGBA{
+		{0, 0}: red, {1, 0}: red, {2, 0}: green,
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// maxChangedPixels bounds the pixels listed in a CHANGED reply. If more
+// changed, the reply falls back to the whole canvas.
+const maxChangedPixels = 65536
+
+// changeTracker remembers the frame in which each pixel last changed, so
+// clients can poll for what changed since their last poll. Frames are
+// numbered from 1; 0 means the pixel never changed.
+type changeTracker struct {
+	frame uint32
+
+	// changed is indexed like the canvas. All fields are guarded by
+	// canvasMu.
+	rect    image.Rectangle
+	changed []uint32
+}
+
+func newChangeTracker(rect image.Rectangle) *changeTracker {
+	return &changeTracker{
+		rect:    rect,
+		changed: make([]uint32, rect.Dx()*rect.Dy()),
+	}
+}
+
+// nextFrame starts a new frame. The caller must hold canvasMu for writing.
+func (t *changeTracker) nextFrame() {
+	t.frame++
+}
+
+// mark records that the pixels in r changed in the current frame. The
+// caller must hold canvasMu for writing.
+func (t *changeTracker) mark(r image.Rectangle) {
+	r = r.Intersect(t.rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := (y-t.rect.Min.Y)*t.rect.Dx() + r.Min.X - t.rect.Min.X
+		row := t.changed[i : i+r.Dx()]
+		for j := range row {
+			row[j] = t.frame
+		}
+	}
+}
+
+// since returns the pixels that changed after frame, or ok false if there
+// are more than maxChangedPixels of them. The caller must hold canvasMu.
+func (t *changeTracker) since(frame uint32) (changed []image.Point, ok bool) {
+	for i, f := range t.changed {
+		if f <= frame {
+			continue
+		}
+		if len(changed) == maxChangedPixels {
+			return nil, false
+		}
+		changed = append(changed, image.Point{t.rect.Min.X + i%t.rect.Dx(), t.rect.Min.Y + i/t.rect.Dx()})
+	}
+	return changed, true
+}
+
+// resize tracks rect from now on, treating every pixel as changed. The
+// caller must hold canvasMu for writing.
+func (t *changeTracker) resize(rect image.Rectangle) {
+	t.rect = rect
+	t.changed = make([]uint32, rect.Dx()*rect.Dy())
+	t.mark(rect)
+}
+
+// encodeChanged returns a CHANGED reply for the pixels that changed after
+// frame: a "CHANGED <frame> <n>" line with the current frame, followed by a
+// PX line for each pixel, using the connection's coordinates. If too many
+// pixels changed, the line reads "CHANGED <frame> STATE" and is followed by
+// a STATE reply instead.
+func (g *Game) encodeChanged(frame uint32, state *connState) []byte {
+	g.canvasMu.RLock()
+	current := g.changes.frame
+	changed, ok := g.changes.since(frame)
+	if !ok {
+		g.canvasMu.RUnlock()
+		return append([]byte(fmt.Sprintf("CHANGED %d STATE\n", current)), g.encodeState()...)
+	}
+
+	reply := []byte(fmt.Sprintf("CHANGED %d %d\n", current, len(changed)))
+	for _, p := range changed {
+		c := g.canvas.RGBAAt(p.X, p.Y)
+		reply = fmt.Appendf(reply, "PX %d %d %02x%02x%02x\n", p.X-state.offsetX, p.Y-state.offsetY, c.R, c.G, c.B)
+	}
+	g.canvasMu.RUnlock()
+	return reply
+}
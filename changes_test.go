@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChanged(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.changes = newChangeTracker(g.canvas.Rect)
+	c := connect(t, g)
+
+	c.do("PX 0 0 ff0000", "PX 3 3 0000ff")
+	g.Render()
+	c.do("PX 2 1 00ff00")
+	g.Render()
+
+	// only the pixel of the second frame changed since the first
+	got := strings.Join(c.do("CHANGED 1"), "\n")
+	if want := "CHANGED 2 1\nPX 2 1 00ff00"; got != want {
+		t.Errorf("CHANGED 1 replied %q, want %q", got, want)
+	}
+	got = strings.Join(c.do("CHANGED 0"), "\n")
+	if want := "CHANGED 2 3\nPX 0 0 ff0000\nPX 2 1 00ff00\nPX 3 3 0000ff"; got != want {
+		t.Errorf("CHANGED 0 replied %q, want %q", got, want)
+	}
+	got = strings.Join(c.do("CHANGED 2"), "\n")
+	if want := "CHANGED 2 0"; got != want {
+		t.Errorf("CHANGED 2 replied %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+)
+
+// depthBuckets is the number of queue depth histogram buckets: one for an
+// empty queue, then one per power of two.
+const depthBuckets = 33
+
+// depthHistogram counts the pixel queue depths seen at the start of each
+// frame, see CHANSTATS. Bucket 0 counts empty queues, bucket i > 0 depths
+// from 2^(i-1) to 2^i - 1. It is written on the render goroutine and read
+// anywhere.
+type depthHistogram struct {
+	buckets [depthBuckets]atomic.Uint64
+	max     atomic.Int64
+}
+
+// sample records a queue depth.
+func (h *depthHistogram) sample(depth int) {
+	h.buckets[min(bits.Len(uint(depth)), depthBuckets-1)].Add(1)
+	if int64(depth) > h.max.Load() {
+		h.max.Store(int64(depth))
+	}
+}
+
+// encodeChanStats returns a CHANSTATS reply: the queue capacity, the number
+// of samples, the deepest queue seen and a lo-hi=count pair per non-empty
+// bucket.
+func (g *Game) encodeChanStats() []byte {
+	var b strings.Builder
+	counts := make([]uint64, depthBuckets)
+	var samples uint64
+	for i := range counts {
+		counts[i] = g.queueDepths.buckets[i].Load()
+		samples += counts[i]
+	}
+
+	fmt.Fprintf(&b, "CHANSTATS capacity=%d samples=%d max=%d", g.queueCapacity(), samples, g.queueDepths.max.Load())
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		lo, hi := 0, 0
+		if i > 0 {
+			lo, hi = 1<<(i-1), 1<<i-1
+		}
+		fmt.Fprintf(&b, " %d-%d=%d", lo, hi, count)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChanStats(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	// one frame each with an empty queue and 5 writes, two with 100
+	g.Render()
+	for _, n := range []int{5, 100, 100} {
+		for i := 0; i < n; i++ {
+			c.send(fmt.Sprintf("PX %d %d ff0000", i%20, i/20))
+		}
+		c.sync()
+		g.Render()
+	}
+
+	want := fmt.Sprintf("CHANSTATS capacity=%d samples=4 max=100 0-0=1 4-7=1 64-127=2", g.queueCapacity())
+	if got := c.do("AUTH secret", "CHANSTATS"); len(got) != 2 || got[1] != want {
+		t.Errorf("CHANSTATS = %q, want %q", got, want)
+	}
+}
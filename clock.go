@@ -0,0 +1,17 @@
+package main
+
+import "time"
+
+// clock tells the time for the features timed in wall clock time rather
+// than frames, like -animation, so tests can move it along themselves.
+type clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseColor parses a protocol color string into a premultiplied color.
+// Supported forms are ww (grayscale), rgb (CSS shorthand, each digit
+// doubled), rrggbb, rrggbbaa and hsv:h,s,v.
+func parseColor(s string) (color.RGBA, bool) {
+	if spec, ok := strings.CutPrefix(s, "hsv:"); ok {
+		return parseHSV(spec)
+	}
+	switch len(s) {
+	case 2:
+		gray, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		return color.RGBA{uint8(gray), uint8(gray), uint8(gray), 255}, true
+	case 3:
+		rgb, err := strconv.ParseUint(s, 16, 12)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		r, g, b := uint8(rgb>>8&0xf), uint8(rgb>>4&0xf), uint8(rgb&0xf)
+		return color.RGBA{r * 0x11, g * 0x11, b * 0x11, 255}, true
+	case 6:
+		rgb, err := strconv.ParseUint(s, 16, 24)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}, true
+	case 8:
+		rgba, err := strconv.ParseUint(s, 16, 32)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		c := color.NRGBA{uint8(rgba >> 24), uint8(rgba >> 16), uint8(rgba >> 8), uint8(rgba)}
+		return color.RGBAModel.Convert(c).(color.RGBA), true
+	}
+	return color.RGBA{}, false
+}
+
+// parseHSV parses the h,s,v of an hsv: color. The hue is in degrees and
+// wraps around, saturation and value range from 0 to 1.
+func parseHSV(spec string) (color.RGBA, bool) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return color.RGBA{}, false
+	}
+	var hsv [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return color.RGBA{}, false
+		}
+		hsv[i] = v
+	}
+	h, s, v := hsv[0], hsv[1], hsv[2]
+	if s < 0 || s > 1 || v < 0 || v > 1 {
+		return color.RGBA{}, false
+	}
+	return hsvToRGB(h, s, v), true
+}
+
+// hsvToRGB converts a hue in degrees, wrapping around, and a saturation and
+// value in [0, 1] to an opaque color.
+func hsvToRGB(h, s, v float64) color.RGBA {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	sector := h / 60
+	chroma := v * s
+	x := chroma * (1 - math.Abs(math.Mod(sector, 2)-1))
+
+	var r, g, b float64
+	switch int(sector) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := v - chroma
+	channel := func(c float64) uint8 { return uint8(math.Round((c + m) * 255)) }
+	return color.RGBA{channel(r), channel(g), channel(b), 255}
+}
+
+// parseColor parses a color like the package level parseColor, taking the
+// -gray-mode into account: with grayGamma, the grayscale form is linear
+// luminance, so 80 emits half the light of ff rather than about a fifth.
+// Anything else is looked up in the palette.
+func (g *Game) parseColor(s string) (color.RGBA, bool) {
+	c, ok := parseColor(s)
+	if !ok {
+		return g.palette.lookup(s)
+	}
+	if ok && len(s) == 2 && g.grayGamma {
+		v := toSRGB(float32(c.R) / 255)
+		c = color.RGBA{v, v, v, 255}
+	}
+	return c, ok
+}
+
+// readFormat selects the color form of PX read replies.
+type readFormat int
+
+const (
+	// readRGB always replies rrggbb.
+	readRGB readFormat = iota
+	// readGray replies the luma as ww, losing any hue.
+	readGray
+	// readAuto replies ww for gray pixels and rrggbb otherwise, so clients
+	// must tell the forms apart by length.
+	readAuto
+)
+
+var readFormats = map[string]readFormat{
+	"rgb":  readRGB,
+	"gray": readGray,
+	"auto": readAuto,
+}
+
+// formatColor formats c for a PX read reply according to the -read-format.
+// Alpha is never included.
+func (g *Game) formatColor(c color.RGBA) string {
+	switch {
+	case g.readFormat == readGray:
+		luma := uint8((299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)) / 1000)
+		return fmt.Sprintf("%02x", luma)
+	case g.readFormat == readAuto && c.R == c.G && c.G == c.B:
+		return fmt.Sprintf("%02x", c.R)
+	}
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// colorFormats lists the color forms parseColor accepts, as shown in HELP,
+// including names once the palette has any, followed by how grayscale colors
+// are interpreted.
+func (g *Game) colorFormats() []string {
+	gray := "gray=direct"
+	if g.grayGamma {
+		gray = "gray=gamma"
+	}
+	formats := []string{"ww", "rgb", "rrggbb", "rrggbbaa", "hsv:h,s,v"}
+	if !g.palette.empty() {
+		formats = append(formats, "name")
+	}
+	return append(formats, gray)
+}
+
+// srgbToLinear and linearToSRGB convert between 8 bit sRGB values and
+// linear light. linearToSRGB is indexed by linear light scaled to
+// 0..len(linearToSRGB)-1.
+var (
+	srgbToLinear [256]float32
+	linearToSRGB [4096]uint8
+)
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = float32(v)
+	}
+	for i := range linearToSRGB {
+		v := float64(i) / float64(len(linearToSRGB)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		linearToSRGB[i] = uint8(math.Round(v * 255))
+	}
+}
+
+// toSRGB converts linear light in [0, 1] to an 8 bit sRGB value.
+func toSRGB(v float32) uint8 {
+	return linearToSRGB[int(min(max(v, 0), 1)*float32(len(linearToSRGB)-1)+0.5)]
+}
+
+// blendLinear composites the premultiplied color c over the premultiplied
+// pixel p like setPixel does, but mixes the colors in linear light rather
+// than in sRGB. A 50% white over black then comes out as 188 instead of 128,
+// which is closer to how the eye perceives the mix, most visibly in brighter
+// edges of translucent strokes.
+func blendLinear(p []uint8, c color.RGBA) {
+	srcA := float32(c.A) / 255
+	dstA := float32(p[3]) / 255
+	outA := srcA + dstA*(1-srcA)
+	if outA == 0 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+
+	src := [3]uint8{c.R, c.G, c.B}
+	for i := 0; i < 3; i++ {
+		// mix straight colors in linear light, weighted by coverage
+		var s, d float32
+		if c.A != 0 {
+			s = srgbToLinear[min(int(src[i])*255/int(c.A), 255)]
+		}
+		if p[3] != 0 {
+			d = srgbToLinear[min(int(p[i])*255/int(p[3]), 255)]
+		}
+		straight := (s*srcA + d*dstA*(1-srcA)) / outA
+		p[i] = uint8(float32(toSRGB(straight))*outA + 0.5)
+	}
+	p[3] = uint8(outA*255 + 0.5)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestLinearBlend(t *testing.T) {
+	g := newTestGame(t, 2, 1)
+	g.linearBlend = true
+	c := connect(t, g)
+
+	c.do("PX 0 0 000000", "PX 0 0 ffffff80", "PX 1 0 ffffff", "PX 1 0 00000080")
+	canvas := g.Render()
+
+	// half of the light of white is about 188 in sRGB, not 128, whichever
+	// of black and white is on top
+	for x := 0; x < 2; x++ {
+		got := canvas.RGBAAt(x, 0)
+		if got.R < 186 || got.R > 190 || got.R != got.G || got.G != got.B || got.A != 255 {
+			t.Errorf("pixel %d = %v, want gray about 188", x, got)
+		}
+	}
+}
+
+func TestSRGBBlend(t *testing.T) {
+	g := newTestGame(t, 1, 1)
+	c := connect(t, g)
+
+	c.do("PX 0 0 000000", "PX 0 0 ffffff80")
+	if got := g.Render().RGBAAt(0, 0); got != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("sRGB blend of half white over black = %v, want 128 gray", got)
+	}
+}
+
+func TestParseColorShortForms(t *testing.T) {
+	tests := []struct {
+		s    string
+		want color.RGBA
+		ok   bool
+	}{
+		{"f00", red, true},
+		{"fff", white, true},
+		{"08c", color.RGBA{0x00, 0x88, 0xcc, 0xff}, true},
+		{"g00", color.RGBA{}, false},
+		{"ff0", color.RGBA{0xff, 0xff, 0x00, 0xff}, true},
+		{"ff00", color.RGBA{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseColor(test.s)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseColor(%q) = %v, %v; want %v, %v", test.s, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseHSV(t *testing.T) {
+	tests := []struct {
+		s    string
+		want color.RGBA
+		ok   bool
+	}{
+		{"hsv:0,1,1", red, true},
+		{"hsv:120,1,1", green, true},
+		{"hsv:240,1,1", blue, true},
+		{"hsv:360,1,1", red, true},
+		{"hsv:-240,1,1", green, true},
+		{"hsv:480,1,1", green, true},
+		{"hsv:0,0,1", white, true},
+		{"hsv:0,1,0", black, true},
+		{"hsv:0,2,1", color.RGBA{}, false},
+		{"hsv:0,1", color.RGBA{}, false},
+		{"hsv:NaN,1,1", color.RGBA{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseColor(test.s)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseColor(%q) = %v, %v; want %v, %v", test.s, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestGrayModes(t *testing.T) {
+	for _, test := range []struct {
+		gamma bool
+		want  uint8
+	}{
+		{false, 0x80},
+		// half the light of white
+		{true, 188},
+	} {
+		g := newTestGame(t, 2, 2)
+		g.grayGamma = test.gamma
+		c := connect(t, g)
+
+		c.do("PX 1 1 80", "PX 0 0 808080")
+		canvas := g.Render()
+		if got, want := canvas.RGBAAt(1, 1), (color.RGBA{test.want, test.want, test.want, 255}); got != want {
+			t.Errorf("gamma %v: PX 1 1 80 drew %v, want %v", test.gamma, got, want)
+		}
+		// only the grayscale form is affected
+		if got, want := canvas.RGBAAt(0, 0), (color.RGBA{0x80, 0x80, 0x80, 255}); got != want {
+			t.Errorf("gamma %v: PX 0 0 808080 drew %v, want %v", test.gamma, got, want)
+		}
+	}
+}
+
+func TestColors(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+
+	want := "COLORS ww rgb rrggbb rrggbbaa hsv:h,s,v gray=direct"
+	if got := c.do("COLORS"); len(got) != 1 || got[0] != want {
+		t.Errorf("COLORS = %q, want %q", got, want)
+	}
+
+	g.grayGamma = true
+	c.do("AUTH secret", "PALETTE set sky=87ceeb")
+	want = "COLORS ww rgb rrggbb rrggbbaa hsv:h,s,v name gray=gamma"
+	if got := c.do("COLORS"); len(got) != 1 || got[0] != want {
+		t.Errorf("COLORS with a palette = %q, want %q", got, want)
+	}
+}
+
+func TestReadFormats(t *testing.T) {
+	for _, test := range []struct {
+		format    string
+		gray, rgb string
+	}{
+		{"rgb", "PX 0 0 808080", "PX 1 0 ff8000"},
+		// luma of ff8000: (299*0xff + 587*0x80) / 1000 = 0x97
+		{"gray", "PX 0 0 80", "PX 1 0 97"},
+		{"auto", "PX 0 0 80", "PX 1 0 ff8000"},
+	} {
+		g := newTestGame(t, 2, 1)
+		g.readFormat = readFormats[test.format]
+		c := connect(t, g)
+		c.do("PX 0 0 808080", "PX 1 0 ff8000")
+		g.Render()
+
+		got := c.do("PX 0 0", "PX 1 0")
+		if len(got) != 2 || got[0] != test.gray || got[1] != test.rgb {
+			t.Errorf("-read-format %s replied %q, want %q and %q", test.format, got, test.gray, test.rgb)
+		}
+	}
+}
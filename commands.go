@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CommandHandler handles a custom command. args are the command's fields
+// after its name. Replies written to w go to the client; a returned error is
+// reported to the client as an ERROR line.
+type CommandHandler func(args []string, w io.Writer, state *connState) error
+
+// commandRegistry holds the custom commands added with RegisterCommand.
+type commandRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]CommandHandler
+}
+
+// RegisterCommand adds a custom command, replacing an earlier one of the
+// same name. Built-in commands always take precedence over custom ones.
+func (g *Game) RegisterCommand(name string, handler CommandHandler) {
+	g.commands.mu.Lock()
+	defer g.commands.mu.Unlock()
+
+	if g.commands.handlers == nil {
+		g.commands.handlers = make(map[string]CommandHandler)
+	}
+	g.commands.handlers[name] = handler
+}
+
+// runCustomCommand runs the custom command named by fields[0], if there is
+// one.
+func (g *Game) runCustomCommand(fields []string, state *connState) {
+	g.commands.mu.RLock()
+	handler, ok := g.commands.handlers[fields[0]]
+	g.commands.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	err := handler(fields[1:], state, state)
+	if err != nil {
+		state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+	}
+}
+
+// Write queues a copy of p as a reply, so connState can be handed to code
+// expecting an io.Writer.
+func (s *connState) Write(p []byte) (int, error) {
+	s.write(append([]byte(nil), p...))
+	return len(p), nil
+}
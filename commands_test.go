@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+func ExampleGame_RegisterCommand() {
+	g := newGame(800, 600, 1024, 1)
+	defer g.shutdown()
+
+	g.RegisterCommand("HELLO", func(args []string, w io.Writer, state *connState) error {
+		if len(args) == 0 {
+			return fmt.Errorf("HELLO needs a name")
+		}
+		_, err := fmt.Fprintf(w, "HELLO %s\n", strings.Join(args, " "))
+		return err
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go g.handleConnection(server)
+	go fmt.Fprint(client, "HELLO pixel flut\nHELLO\n")
+
+	r := bufio.NewReader(client)
+	for i := 0; i < 2; i++ {
+		line, _ := r.ReadString('\n')
+		fmt.Print(line)
+	}
+	// Output:
+	// HELLO pixel flut
+	// ERROR HELLO needs a name
+}
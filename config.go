@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// secretFlags are left out of the reported configuration.
+var secretFlags = map[string]bool{
+	"admin-token": true,
+}
+
+// Config is the effective configuration of a running server, as reported by
+// CONFIG.
+type Config struct {
+	Version string `json:"version"`
+	// Width and Height are the canvas size at startup; RESIZE may have
+	// changed it since
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Flags holds the value of every command line flag, defaults included
+	Flags map[string]string `json:"flags"`
+}
+
+// newConfig collects the configuration from the parsed flags.
+func newConfig(flags *flag.FlagSet, width, height int) Config {
+	config := Config{
+		Version: version,
+		Width:   width,
+		Height:  height,
+		Flags:   make(map[string]string),
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if secretFlags[f.Name] {
+			if f.Value.String() != "" {
+				config.Flags[f.Name] = "(set)"
+			}
+			return
+		}
+		config.Flags[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// encodeConfig returns a CONFIG reply: the configuration as JSON on a single
+// line.
+func (g *Game) encodeConfig() []byte {
+	reply, _ := json.Marshal(g.config)
+	return append(reply, '\n')
+}
+
+// encodeLimits returns a LIMITS reply: the limits in force for clients as
+// name=value pairs on a single line, named like the flags setting them. They
+// are taken from the game rather than the flags, which main may have
+// clamped. A limit of 0 means none; the number of connections is never
+// limited.
+func (g *Game) encodeLimits() []byte {
+	var rate float64
+	if g.limiters != nil {
+		rate = g.limiters.rate
+	}
+	var cooldown time.Duration
+	if g.cooldowns != nil {
+		cooldown = g.cooldowns.period
+	}
+	var pixelCooldownFrames uint32
+	if g.flicker != nil {
+		pixelCooldownFrames = g.flicker.frames
+	}
+
+	reply := fmt.Sprintf("LIMITS max-conns=0 max-line=%d rate-limit=%s cooldown=%s queue-size=%d pixel-cooldown-frames=%d lock-duration=%s max-lock-area=%d max-locks-per-ip=%d\n",
+		g.maxLine, strconv.FormatFloat(rate, 'f', -1, 64), cooldown, g.queueCapacity(),
+		pixelCooldownFrames, g.lockDuration, g.maxLockArea, g.maxLocksPerIP)
+	return []byte(reply)
+}
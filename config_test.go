@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+	"time"
+)
+
+// testFlags returns a flag set like the one main parses, reduced to what
+// the tests look at.
+func testFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	flags := flag.NewFlagSet("pixelflut", flag.ContinueOnError)
+	flags.Int("width", 800, "")
+	flags.Int("height", 600, "")
+	flags.String("admin-token", "", "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestConfigStartupSize(t *testing.T) {
+	g := newTestGame(t, 20, 10)
+	g.config = newConfig(testFlags(t, "-width", "20", "-height", "10", "-admin-token", "secret"), 20, 10)
+	c := connect(t, g)
+
+	c.do("AUTH secret", "RESIZE 30 40")
+	renderTask(t, g)
+	got := c.do("CONFIG")
+	if len(got) != 1 {
+		t.Fatalf("CONFIG replied %q", got)
+	}
+	var config Config
+	if err := json.Unmarshal([]byte(got[0]), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 20 || config.Height != 10 {
+		t.Errorf("CONFIG size = %dx%d, want the startup size 20x10", config.Width, config.Height)
+	}
+	if config.Flags["width"] != "20" {
+		t.Errorf("CONFIG -width = %q, want 20", config.Flags["width"])
+	}
+	if token := config.Flags["admin-token"]; token != "(set)" {
+		t.Errorf("CONFIG -admin-token = %q, want it hidden", token)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	// 1000 updates don't split evenly into 3 shards
+	g := newGame(10, 10, 1000, 3)
+	g.adminToken = "secret"
+	t.Cleanup(g.shutdown)
+	c := connect(t, g)
+
+	want := "LIMITS max-conns=0 max-line=10240 rate-limit=0 cooldown=0s queue-size=999 " +
+		"pixel-cooldown-frames=0 lock-duration=30s max-lock-area=4096 max-locks-per-ip=4"
+	if got := c.do("LIMITS"); len(got) != 1 || got[0] != want {
+		t.Errorf("LIMITS with the defaults = %q, want %q", got, want)
+	}
+
+	// configured like main does, which clamps -max-line 10 to 64
+	g.maxLine = 64
+	g.limiters = newRateLimiters(2.5, 16)
+	g.cooldowns = newCooldowns(250*time.Millisecond, 16)
+	g.flicker = newFlickerGuard(3, g.canvas.Rect)
+	g.lockDuration = time.Minute
+	g.maxLockArea = 0
+	g.maxLocksPerIP = 2
+	want = "LIMITS max-conns=0 max-line=64 rate-limit=2.5 cooldown=250ms queue-size=999 " +
+		"pixel-cooldown-frames=3 lock-duration=1m0s max-lock-area=0 max-locks-per-ip=2"
+	if got := c.do("LIMITS"); len(got) != 1 || got[0] != want {
+		t.Errorf("LIMITS = %q, want %q", got, want)
+	}
+}
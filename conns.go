@@ -0,0 +1,37 @@
+package main
+
+import "sync"
+
+// ipConns counts the open connections per client IP. Connections over a
+// Unix socket have no IP and are counted under the empty string. The zero
+// value is ready to use.
+type ipConns struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// add records a connection from ip opening.
+func (c *ipConns) add(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[ip]++
+}
+
+// remove records a connection from ip closing.
+func (c *ipConns) remove(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip]--; c.counts[ip] <= 0 {
+		delete(c.counts, ip)
+	}
+}
+
+// count returns the number of open connections from ip.
+func (c *ipConns) count(ip string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[ip]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// Display is an output device the canvas is pushed to: the window by
+// default, see windowDisplay, or a framebuffer or an LED matrix.
+type Display interface {
+	// Set shows the premultiplied color c at canvas position (x, y).
+	// Positions the device doesn't have are ignored.
+	Set(x, y int, c color.RGBA)
+	// Flush makes the pixels set since the last Flush visible.
+	Flush()
+}
+
+// regionDisplay is a Display that takes whole regions of the canvas at once
+// and draws the background itself, like the window.
+type regionDisplay interface {
+	Display
+	// SetRegion shows the canvas pixels of src within r as they are.
+	SetRegion(src *image.RGBA, r image.Rectangle)
+}
+
+// updateDisplay pushes the pixels changed within r to the display, with the
+// background showing through where they aren't opaque. It must only be
+// called on the render goroutine.
+func (g *Game) updateDisplay(r image.Rectangle) {
+	r = r.Intersect(g.canvas.Rect)
+	if r.Empty() {
+		return
+	}
+	if d, ok := g.display.(regionDisplay); ok {
+		d.SetRegion(g.canvas, r)
+		d.Flush()
+		return
+	}
+	width, height := g.canvas.Rect.Dx(), g.canvas.Rect.Dy()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := g.canvas.RGBAAt(x, y)
+			if g.background != nil && c.A != 255 {
+				// c over the background, both premultiplied
+				bg := g.background.at(x, y, width, height)
+				inv := 255 - uint32(c.A)
+				c = color.RGBA{
+					uint8(uint32(c.R) + uint32(bg.R)*inv/255),
+					uint8(uint32(c.G) + uint32(bg.G)*inv/255),
+					uint8(uint32(c.B) + uint32(bg.B)*inv/255),
+					uint8(uint32(c.A) + uint32(bg.A)*inv/255),
+				}
+			}
+			g.display.Set(x, y, c)
+		}
+	}
+	g.display.Flush()
+}
+
+// monoDisplay shows only black and white on its Display, e.g. for e-paper.
+// The canvas keeps its colors, so reads are unaffected.
+type monoDisplay struct {
+	Display
+	threshold uint8
+}
+
+// withMono returns d thresholding every pixel's luma against threshold, or d
+// itself if threshold is 0.
+func withMono(d Display, threshold int) Display {
+	if threshold <= 0 {
+		return d
+	}
+	return monoDisplay{d, uint8(min(threshold, 255))}
+}
+
+// Set shows white if the luma of c on black is at least the threshold, and
+// black otherwise.
+func (m monoDisplay) Set(x, y int, c color.RGBA) {
+	luma := (299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)) / 1000
+	if luma >= uint32(m.threshold) {
+		m.Display.Set(x, y, color.RGBA{255, 255, 255, 255})
+	} else {
+		m.Display.Set(x, y, color.RGBA{0, 0, 0, 255})
+	}
+}
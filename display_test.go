@@ -0,0 +1,189 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// recordingDisplay is a Display remembering what it was sent.
+type recordingDisplay struct {
+	set     map[image.Point]color.RGBA
+	flushes int
+}
+
+func (d *recordingDisplay) Set(x, y int, c color.RGBA) {
+	if d.set == nil {
+		d.set = make(map[image.Point]color.RGBA)
+	}
+	d.set[image.Point{x, y}] = c
+}
+
+func (d *recordingDisplay) Flush() {
+	d.flushes++
+}
+
+func TestDisplayUpdates(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	display := &recordingDisplay{}
+	g.display = display
+	c := connect(t, g)
+
+	c.do("PX 1 2 ff0000")
+	g.headlessFrame()
+	if got := display.set[image.Point{1, 2}]; got != red {
+		t.Errorf("display pixel = %v, want %v", got, red)
+	}
+	if display.flushes != 1 {
+		t.Errorf("display flushed %d times, want 1", display.flushes)
+	}
+
+	// nothing changed, so nothing is sent
+	display.set = nil
+	g.headlessFrame()
+	if len(display.set) != 0 || display.flushes != 1 {
+		t.Errorf("idle frame set %d pixels and flushed %d times, want none", len(display.set), display.flushes-1)
+	}
+}
+
+func TestRunWindowWithoutDisplay(t *testing.T) {
+	t.Setenv("DISPLAY", "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	if hasDisplay() {
+		t.Skip("this system doesn't use X11 or Wayland")
+	}
+
+	g := newTestGame(t, 4, 4)
+	addr := listen(t, g)
+	stopped := make(chan struct{})
+	go func() {
+		g.runWindow(0, 4, 4)
+		close(stopped)
+	}()
+
+	// rendered without a window, so the pixel shows up unaided
+	c := dial(t, "tcp", addr)
+	c.do("PX 1 1 ff0000")
+	deadline := time.Now().Add(5 * time.Second)
+	for g.pixel(1, 1) != red {
+		if time.Now().After(deadline) {
+			t.Fatal("the pixel was never drawn")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	g.shutdown()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWindow didn't return on shutdown")
+	}
+}
+
+func TestDisplayBackground(t *testing.T) {
+	g := newTestGame(t, 2, 1)
+	g.background = newColorBackground(blue)
+	display := &recordingDisplay{}
+	g.display = display
+	c := connect(t, g)
+
+	c.do("PX 1 0 ff0000")
+	g.markDirty(g.canvas.Rect)
+	g.headlessFrame()
+	if got := display.set[image.Point{0, 0}]; got != blue {
+		t.Errorf("transparent pixel shows %v, want the background %v", got, blue)
+	}
+	if got := display.set[image.Point{1, 0}]; got != red {
+		t.Errorf("opaque pixel shows %v, want %v", got, red)
+	}
+	if got := g.pixel(0, 0); got != (color.RGBA{}) {
+		t.Errorf("canvas pixel = %v, want the background kept off the canvas", got)
+	}
+}
+
+func TestWindowDisplay(t *testing.T) {
+	g := newTestGame(t, 4, 3)
+	g.background = newColorBackground(blue)
+	g.window = &windowDisplay{}
+	g.display = g.window
+	c := connect(t, g)
+
+	at := func(x, y int) color.RGBA {
+		i := (y*4 + x) * 4
+		p := g.window.pix[i : i+4]
+		return color.RGBA{p[0], p[1], p[2], p[3]}
+	}
+
+	c.do("PX 1 2 ff0000", "PX 3 0 00ff0080")
+	g.Render()
+	g.updateWindow()
+	if got := at(1, 2); got != red {
+		t.Errorf("window pixel = %v, want %v", got, red)
+	}
+	// the window draws the background beneath the canvas itself
+	if got, want := at(3, 0), (color.RGBA{0, 0x80, 0, 0x80}); got != want {
+		t.Errorf("translucent window pixel = %v, want it uncomposited %v", got, want)
+	}
+	if !g.window.dirty.Empty() {
+		t.Errorf("rows %v left to upload after the update", g.window.dirty)
+	}
+
+	c.do("AUTH secret", "RESIZE 5 5")
+	renderTask(t, g)
+	g.updateWindow()
+	if got := g.window.frame.Bounds(); got != image.Rect(0, 0, 5, 5) {
+		t.Errorf("window frame after RESIZE = %v, want 5x5", got)
+	}
+	if got := g.window.pix[(2*5+1)*4]; got != 0xff {
+		t.Errorf("window pixel after RESIZE has red %#x, want the canvas redrawn", got)
+	}
+}
+
+func TestCheckerboardBackground(t *testing.T) {
+	b := &background{}
+	light, dark := color.RGBA{0x99, 0x99, 0x99, 255}, color.RGBA{0x66, 0x66, 0x66, 255}
+	if got := b.at(0, 0, 32, 32); got != light {
+		t.Errorf("checkerboard at (0, 0) = %v, want %v", got, light)
+	}
+	if got := b.at(checkerSize, 0, 32, 32); got != dark {
+		t.Errorf("checkerboard at (%d, 0) = %v, want %v", checkerSize, got, dark)
+	}
+}
+
+func TestMonoDisplay(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	for _, test := range []struct {
+		threshold int
+		want      color.RGBA
+	}{
+		{100, white},
+		{128, white},
+		{129, black},
+		{200, black},
+	} {
+		display := &recordingDisplay{}
+		withMono(display, test.threshold).Set(1, 1, gray)
+		if got := display.set[image.Point{1, 1}]; got != test.want {
+			t.Errorf("threshold %d shows gray as %v, want %v", test.threshold, got, test.want)
+		}
+	}
+
+	if _, ok := withMono(&recordingDisplay{}, 0).(monoDisplay); ok {
+		t.Error("withMono with threshold 0 thresholds anyway")
+	}
+
+	// only the output is thresholded, reads see the color
+	g := newTestGame(t, 4, 4)
+	display := &recordingDisplay{}
+	g.display = withMono(display, 100)
+	c := connect(t, g)
+	c.do("PX 1 1 808080")
+	g.headlessFrame()
+	if got := display.set[image.Point{1, 1}]; got != white {
+		t.Errorf("mono display shows gray as %v, want %v", got, white)
+	}
+	if got := c.do("PX 1 1"); len(got) != 1 || got[0] != "PX 1 1 808080" {
+		t.Errorf("PX 1 1 with a mono display = %q, want the gray", got)
+	}
+}
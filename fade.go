@@ -0,0 +1,147 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+const (
+	// maxFades bounds the pixels fading at the same time.
+	maxFades = 1 << 16
+	// maxFadeFrames bounds the length of a fade, about a minute at 60
+	// frames per second.
+	maxFadeFrames = 60 * 60
+)
+
+// pixelFade moves a pixel from one premultiplied color to another over a
+// number of frames, see PXFADE.
+type pixelFade struct {
+	from, to      color.RGBA
+	frame, frames int
+	owner         uint32
+}
+
+// queuedFade is a PXFADE waiting for the next frame to start.
+type queuedFade struct {
+	update PixelUpdate
+	frames int
+}
+
+// fadeQueue holds the PXFADEs sent since the last frame, at most maxFades.
+// started is closed once they started, see connState.taskFence.
+type fadeQueue struct {
+	mu      sync.Mutex
+	pending []queuedFade
+	started chan struct{}
+}
+
+// fadePixel fades update in from the pixel's current color over the given
+// number of frames, replacing any fade of that pixel still running. The fade
+// starts in the next frame, after the pixel writes queued by then, or on
+// FLUSH while the connection is holding.
+func (g *Game) fadePixel(state *connState, update PixelUpdate, frames int) {
+	fade := queuedFade{update, frames}
+	if state.holding {
+		if len(state.held)+len(state.heldFades) >= maxHeldPixels {
+			g.stats.dropped.Add(1)
+			return
+		}
+		state.heldFades = append(state.heldFades, fade)
+		return
+	}
+
+	g.fadeQueue.mu.Lock()
+	defer g.fadeQueue.mu.Unlock()
+	if len(g.fadeQueue.pending) >= maxFades {
+		g.stats.dropped.Add(1)
+		return
+	}
+	g.fadeQueue.pending = append(g.fadeQueue.pending, fade)
+	if g.fadeQueue.started == nil {
+		g.fadeQueue.started = make(chan struct{})
+	}
+	// a write sent after the fade must end it rather than be faded over
+	state.taskFence = g.fadeQueue.started
+}
+
+// startFades starts the fades queued since the last frame. It must only be
+// called on the render goroutine, with canvasMu held for writing.
+func (g *Game) startFades() {
+	g.fadeQueue.mu.Lock()
+	pending, started := g.fadeQueue.pending, g.fadeQueue.started
+	g.fadeQueue.pending, g.fadeQueue.started = nil, nil
+	g.fadeQueue.mu.Unlock()
+
+	for _, fade := range pending {
+		g.startFade(fade)
+	}
+	if started != nil {
+		close(started)
+	}
+}
+
+// startFade starts fade, replacing any fade of the pixel still running. The
+// caller must hold canvasMu for writing.
+func (g *Game) startFade(fade queuedFade) {
+	at := image.Point{int(fade.update.x), int(fade.update.y)}
+	if !at.In(g.canvas.Rect) {
+		return
+	}
+	if _, ok := g.fades[at]; !ok && len(g.fades) >= maxFades {
+		g.stats.dropped.Add(1)
+		return
+	}
+	if g.fades == nil {
+		g.fades = make(map[image.Point]*pixelFade)
+	}
+	g.fades[at] = &pixelFade{
+		from:   g.canvas.RGBAAt(at.X, at.Y),
+		to:     fade.update.color,
+		frames: fade.frames,
+		owner:  fade.update.owner,
+	}
+}
+
+// stepFades advances every fade by a frame, ending those that reached their
+// color. Like any write, a step is checked against PROTECT, which ends the
+// fade, and -pixel-cooldown-frames, which delays it to a later frame. The
+// caller must hold canvasMu for writing.
+func (g *Game) stepFades() {
+	for at, fade := range g.fades {
+		if !at.In(g.canvas.Rect) {
+			// lost to a RESIZE
+			delete(g.fades, at)
+			continue
+		}
+		if g.protected.contains(at) {
+			delete(g.fades, at)
+			g.stats.dropped.Add(1)
+			continue
+		}
+		if g.flicker != nil && !g.flicker.allow(at.X, at.Y) {
+			continue
+		}
+
+		fade.frame++
+		c := fade.to
+		if fade.frame < fade.frames {
+			c = lerpColor(fade.from, fade.to, fade.frame, fade.frames)
+		} else {
+			delete(g.fades, at)
+		}
+		// replace rather than composite, or translucent steps would pile up
+		g.setPixel(at.X, at.Y, c, blendReplace)
+		if g.owners != nil {
+			g.owners.record(at.X, at.Y, fade.owner)
+		}
+	}
+}
+
+// lerpColor returns the color i/n of the way from a to b.
+func lerpColor(a, b color.RGBA, i, n int) color.RGBA {
+	lerp := func(a, b uint8) uint8 {
+		return uint8((int(a)*(n-i) + int(b)*i + n/2) / n)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
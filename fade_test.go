@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"testing"
+)
+
+func TestFade(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+	c.do("PX 0 0 000000")
+	g.Render()
+
+	c.do("PXFADE 0 0 ffffff 4")
+	for frame := 1; frame < 4; frame++ {
+		want := uint8((255*frame + 2) / 4)
+		if got := g.Render().RGBAAt(0, 0); got != (color.RGBA{want, want, want, 255}) {
+			t.Errorf("pixel in frame %d of the fade = %v, want gray %d", frame, got, want)
+		}
+	}
+	if got := g.Render().RGBAAt(0, 0); got != white {
+		t.Errorf("pixel at the end of the fade = %v, want %v", got, white)
+	}
+	if len(g.fades) != 0 {
+		t.Errorf("%d fades still running", len(g.fades))
+	}
+}
+
+func TestFadeLatestWins(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+
+	c.do("PXFADE 0 0 ffffff 8", "PXFADE 0 0 ff0000 2")
+	g.Render()
+	if got := g.Render().RGBAAt(0, 0); got != red {
+		t.Errorf("pixel after the second fade = %v, want %v", got, red)
+	}
+	for i := 0; i < 8; i++ {
+		g.Render()
+	}
+	if got := g.pixel(0, 0); got != red {
+		t.Errorf("pixel after the first fade would have ended = %v, want %v", got, red)
+	}
+}
+
+func TestFadeQueue(t *testing.T) {
+	g := newTestGame(t, 64, 64)
+	c := connect(t, g)
+
+	// fades don't take up render tasks, or a client fading in a loop
+	// would hold up COPY and FLUSH for everyone
+	for x := 0; x < 64; x++ {
+		c.send(fmt.Sprintf("PXFADE %d 0 ffffff 2", x))
+	}
+	c.sync()
+	if n := len(g.renderTasks); n != 0 {
+		t.Errorf("%d render tasks queued by PXFADE, want none", n)
+	}
+	g.Render()
+	if len(g.fades) != 64 {
+		t.Errorf("%d fades running, want 64", len(g.fades))
+	}
+}
+
+func TestFadeHeld(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+
+	c.do("HOLD", "PXFADE 0 0 ffffff 2")
+	g.Render()
+	if len(g.fades) != 0 {
+		t.Fatalf("%d fades running while holding, want none", len(g.fades))
+	}
+
+	c.send("FLUSH")
+	renderTask(t, g)
+	if got := g.pixel(0, 0); got != (color.RGBA{128, 128, 128, 128}) {
+		t.Errorf("pixel in the frame of FLUSH = %v, want half way to %v", got, white)
+	}
+	if got := g.Render().RGBAAt(0, 0); got != white {
+		t.Errorf("pixel at the end of the fade = %v, want %v", got, white)
+	}
+}
+
+func TestFadeProtected(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	admin, c := connect(t, g), connect(t, g)
+
+	c.do("PXFADE 0 0 ffffff 8")
+	g.Render()
+	before := g.pixel(0, 0)
+	// protected while fading, the fade must not paint it any further
+	admin.do("AUTH secret", "PROTECT 0 0 1 1")
+	for i := 0; i < 8; i++ {
+		g.Render()
+	}
+	if got := g.pixel(0, 0); got != before {
+		t.Errorf("protected pixel = %v, want %v as it was when protected", got, before)
+	}
+	if len(g.fades) != 0 {
+		t.Errorf("%d fades still running", len(g.fades))
+	}
+}
+
+func TestFadeFlicker(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	g.flicker = newFlickerGuard(2, g.canvas.Rect)
+	c := connect(t, g)
+
+	// every other frame is frozen by the flicker guard, which delays the
+	// steps of the fade rather than skipping them
+	c.do("PXFADE 0 0 ffffff 2")
+	half := color.RGBA{128, 128, 128, 128}
+	for frame, want := range []color.RGBA{half, half, white} {
+		if got := g.Render().RGBAAt(0, 0); got != want {
+			t.Errorf("pixel in frame %d of the fade = %v, want %v", frame+1, got, want)
+		}
+	}
+}
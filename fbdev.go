@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"image/color"
+)
+
+// fbChannel describes where a color channel sits in a framebuffer pixel.
+type fbChannel struct {
+	offset, length uint32
+}
+
+// framebuffer is a memory-mapped Linux framebuffer device (or anything laid
+// out like one).
+type framebuffer struct {
+	mem           []byte
+	width, height int
+	stride        int // bytes per line
+	bytesPerPixel int
+
+	red, green, blue fbChannel
+
+	close func() error
+}
+
+// Set writes c to the framebuffer pixel at (x, y), converting to its pixel
+// format. Transparent pixels end up composited on black.
+func (fb *framebuffer) Set(x, y int, c color.RGBA) {
+	if x < 0 || y < 0 || x >= fb.width || y >= fb.height {
+		return
+	}
+
+	v := fb.red.pack(c.R) | fb.green.pack(c.G) | fb.blue.pack(c.B)
+	i := y*fb.stride + x*fb.bytesPerPixel
+	d := fb.mem[i : i+fb.bytesPerPixel]
+	switch fb.bytesPerPixel {
+	case 2:
+		binary.LittleEndian.PutUint16(d, uint16(v))
+	case 3:
+		d[0], d[1], d[2] = uint8(v), uint8(v>>8), uint8(v>>16)
+	case 4:
+		binary.LittleEndian.PutUint32(d, v)
+	}
+}
+
+// Flush does nothing, as the framebuffer memory is what the screen shows.
+func (fb *framebuffer) Flush() {}
+
+// pack scales the 8 bit value v to the channel's width and moves it into
+// place.
+func (c fbChannel) pack(v uint8) uint32 {
+	if c.length == 0 {
+		return 0
+	}
+	if c.length < 8 {
+		return uint32(v>>(8-c.length)) << c.offset
+	}
+	return uint32(v) << c.offset
+}
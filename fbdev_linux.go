@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl requests from linux/fb.h
+const (
+	fbioGetVScreenInfo = 0x4600
+	fbioGetFScreenInfo = 0x4602
+)
+
+type fbBitfield struct {
+	Offset, Length, MSBRight uint32
+}
+
+// fbVarScreenInfo mirrors struct fb_var_screeninfo.
+type fbVarScreenInfo struct {
+	XRes, YRes                uint32
+	XResVirtual, YResVirtual  uint32
+	XOffset, YOffset          uint32
+	BitsPerPixel, Grayscale   uint32
+	Red, Green, Blue, Transp  fbBitfield
+	NonStd, Activate          uint32
+	Height, Width, AccelFlags uint32
+	PixClock                  uint32
+	LeftMargin, RightMargin   uint32
+	UpperMargin, LowerMargin  uint32
+	HSyncLen, VSyncLen        uint32
+	Sync, VMode, Rotate       uint32
+	Colorspace                uint32
+	Reserved                  [4]uint32
+}
+
+// fbFixScreenInfo mirrors struct fb_fix_screeninfo.
+type fbFixScreenInfo struct {
+	ID                            [16]byte
+	SmemStart                     uintptr
+	SmemLen                       uint32
+	Type, TypeAux, Visual         uint32
+	XPanStep, YPanStep, YWrapStep uint16
+	LineLength                    uint32
+	MmioStart                     uintptr
+	MmioLen                       uint32
+	Accel                         uint32
+	Capabilities                  uint16
+	Reserved                      [2]uint16
+}
+
+// openFramebuffer memory-maps the framebuffer device at path.
+func openFramebuffer(path string) (*framebuffer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vinfo fbVarScreenInfo
+	var finfo fbFixScreenInfo
+	if err := fbIoctl(f, fbioGetVScreenInfo, unsafe.Pointer(&vinfo)); err != nil {
+		return nil, fmt.Errorf("%s: reading screen info: %w", path, err)
+	}
+	if err := fbIoctl(f, fbioGetFScreenInfo, unsafe.Pointer(&finfo)); err != nil {
+		return nil, fmt.Errorf("%s: reading screen info: %w", path, err)
+	}
+
+	bytesPerPixel := int(vinfo.BitsPerPixel / 8)
+	if bytesPerPixel < 2 || bytesPerPixel > 4 {
+		return nil, fmt.Errorf("%s: unsupported pixel format with %d bits per pixel", path, vinfo.BitsPerPixel)
+	}
+
+	size := int(finfo.LineLength) * int(vinfo.YRes)
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("%s: mapping framebuffer: %w", path, err)
+	}
+
+	return &framebuffer{
+		mem:           mem,
+		width:         int(vinfo.XRes),
+		height:        int(vinfo.YRes),
+		stride:        int(finfo.LineLength),
+		bytesPerPixel: bytesPerPixel,
+		red:           fbChannel{vinfo.Red.Offset, vinfo.Red.Length},
+		green:         fbChannel{vinfo.Green.Offset, vinfo.Green.Length},
+		blue:          fbChannel{vinfo.Blue.Offset, vinfo.Blue.Length},
+		close: func() error {
+			return syscall.Munmap(mem)
+		},
+	}, nil
+}
+
+func fbIoctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
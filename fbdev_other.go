@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openFramebuffer is only supported on Linux.
+func openFramebuffer(path string) (*framebuffer, error) {
+	return nil, errors.New("framebuffer output is only supported on Linux")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestFramebufferLayout(t *testing.T) {
+	tests := []struct {
+		name             string
+		bytesPerPixel    int
+		red, green, blue fbChannel
+		// the bytes of pixel (1, 1) after setting it to #ff8040
+		want []byte
+	}{
+		{"xrgb8888", 4, fbChannel{16, 8}, fbChannel{8, 8}, fbChannel{0, 8}, []byte{0x40, 0x80, 0xff, 0x00}},
+		{"bgr888", 3, fbChannel{0, 8}, fbChannel{8, 8}, fbChannel{16, 8}, []byte{0xff, 0x80, 0x40}},
+		// 11111 100000 01000
+		{"rgb565", 2, fbChannel{11, 5}, fbChannel{5, 6}, fbChannel{0, 5}, []byte{0x08, 0xfc}},
+	}
+	for _, test := range tests {
+		// each line is padded by 2 bytes
+		stride := 3*test.bytesPerPixel + 2
+		fb := &framebuffer{
+			mem:           make([]byte, 2*stride),
+			width:         3,
+			height:        2,
+			stride:        stride,
+			bytesPerPixel: test.bytesPerPixel,
+			red:           test.red,
+			green:         test.green,
+			blue:          test.blue,
+		}
+		fb.Set(1, 1, color.RGBA{0xff, 0x80, 0x40, 0xff})
+		// outside the framebuffer, ignored
+		fb.Set(3, 0, white)
+		fb.Set(0, -1, white)
+		fb.Flush()
+
+		want := make([]byte, len(fb.mem))
+		copy(want[stride+test.bytesPerPixel:], test.want)
+		if !bytes.Equal(fb.mem, want) {
+			t.Errorf("%s: framebuffer = % x, want % x", test.name, fb.mem, want)
+		}
+	}
+}
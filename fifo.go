@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"time"
+)
+
+// runFIFO writes the canvas as raw RGBA frames to the file at path, fps times
+// per second until shutdown, e.g. for
+//
+//	ffmpeg -f rawvideo -pix_fmt rgba -s WxH -r fps -i path out.mp4
+//
+// path is usually a named pipe created with mkfifo. Opening it waits for a
+// reader, and when the reader goes away it is opened again. Frames keep the
+// canvas size at startup, so RESIZE crops or pads them rather than breaking
+// the stream. A reader that can't keep up misses frames instead of slowing
+// down the server.
+func (g *Game) runFIFO(path string, fps int) {
+	frame := image.NewRGBA(g.Snapshot().Rect)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Println("Error opening -fifo:", err)
+			return
+		}
+		log.Println("Writing frames to", path)
+		err = g.writeFrames(f, frame, fps)
+		f.Close()
+		if err == nil {
+			return
+		}
+		log.Println("Error writing to -fifo:", err)
+
+		select {
+		case <-g.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// writeFrames writes frames to f at fps until shutdown or a write fails.
+// Ticks that pass while a write blocks are dropped by the ticker, which is
+// what skips frames for a slow reader.
+func (g *Game) writeFrames(f *os.File, frame *image.RGBA, fps int) error {
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		snapshot := g.Snapshot()
+		if snapshot.Rect == frame.Rect {
+			frame = snapshot
+		} else {
+			draw.Draw(frame, frame.Rect, image.Black, image.Point{}, draw.Src)
+			draw.Draw(frame, frame.Rect, snapshot, image.Point{}, draw.Src)
+		}
+		if _, err := f.Write(frame.Pix); err != nil {
+			return err
+		}
+	}
+}
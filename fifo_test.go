@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestFIFOFrameLayout(t *testing.T) {
+	g := newTestGame(t, 3, 2)
+	c := connect(t, g)
+	c.do("PX 0 0 ff0000", "PX 2 1 0000ff80")
+	g.Render()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	written := make(chan error, 1)
+	go func() {
+		written <- g.writeFrames(w, image.NewRGBA(image.Rect(0, 0, 3, 2)), 60)
+		w.Close()
+	}()
+
+	// raw premultiplied RGBA, row by row, without any header or padding
+	frame := make([]byte, 3*2*4)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0xff, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80, 0x80,
+	}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("frame = % x, want % x", frame, want)
+	}
+
+	g.shutdown()
+	if err := <-written; err != nil {
+		t.Errorf("writeFrames = %v after shutdown, want nil", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"strings"
+)
+
+// pixelFilter modifies a single premultiplied RGBA pixel in place.
+type pixelFilter func(p []uint8)
+
+// parseFilter parses a FILTER operation: invert, grayscale, or brightness
+// followed by a signed amount such as brightness+20 or brightness-40.
+func parseFilter(op string) (pixelFilter, bool) {
+	switch {
+	case op == "invert":
+		return func(p []uint8) {
+			// premultiplied, so invert within the pixel's alpha
+			p[0], p[1], p[2] = p[3]-p[0], p[3]-p[1], p[3]-p[2]
+		}, true
+	case op == "grayscale":
+		return func(p []uint8) {
+			luma := uint8((299*uint32(p[0]) + 587*uint32(p[1]) + 114*uint32(p[2])) / 1000)
+			p[0], p[1], p[2] = luma, luma, luma
+		}, true
+	case strings.HasPrefix(op, "brightness"):
+		delta, err := strconv.Atoi(strings.TrimPrefix(op, "brightness"))
+		if err != nil || delta < -255 || delta > 255 {
+			return nil, false
+		}
+		return func(p []uint8) {
+			d := delta * int(p[3]) / 255
+			for i := 0; i < 3; i++ {
+				p[i] = uint8(min(max(int(p[i])+d, 0), int(p[3])))
+			}
+		}, true
+	}
+	return nil, false
+}
+
+// filterRegion queues a render task applying filter to every canvas pixel in
+// r, clipped to the canvas.
+func (g *Game) filterRegion(r image.Rectangle, filter pixelFilter) {
+	g.queueTask(func() {
+		r := r.Intersect(g.canvas.Rect)
+		g.markDirty(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := g.canvas.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				filter(g.canvas.Pix[i : i+4 : i+4])
+				i += 4
+			}
+		}
+	})
+}
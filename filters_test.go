@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestFilterInvert(t *testing.T) {
+	g := newTestGame(t, 3, 1)
+	c := connect(t, g)
+	c.do("PX 0 0 ff0000", "PX 1 0 ffffff80", "PX 2 0 00ff00")
+	g.Render()
+
+	// the last pixel is outside the region
+	c.do("AUTH secret", "FILTER 0 0 2 1 invert")
+	canvas := g.Render()
+
+	want := []color.RGBA{
+		{0, 255, 255, 255},
+		// inverted within its alpha, so transparent white turns black
+		{0, 0, 0, 128},
+		green,
+	}
+	for x, want := range want {
+		if got := canvas.RGBAAt(x, 0); got != want {
+			t.Errorf("pixel %d after FILTER invert = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, op := range []string{"invert", "grayscale", "brightness+20", "brightness-255"} {
+		if _, ok := parseFilter(op); !ok {
+			t.Errorf("parseFilter(%q) failed", op)
+		}
+	}
+	for _, op := range []string{"", "blur", "brightness", "brightness+256"} {
+		if _, ok := parseFilter(op); ok {
+			t.Errorf("parseFilter(%q) succeeded", op)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "image"
+
+// flickerGuard limits how often a single pixel may change, so clients can't
+// make it flicker by alternating colors. Writes to a pixel within the frame
+// that last changed it are always allowed, so the last write of a frame
+// wins; after that the pixel is frozen for the following frames.
+type flickerGuard struct {
+	frames uint32 // minimum number of frames between changes
+	frame  uint32
+
+	// changed holds the frame each pixel last changed in plus one, zero
+	// meaning never. It is indexed like the canvas and guarded by canvasMu.
+	rect    image.Rectangle
+	changed []uint32
+}
+
+func newFlickerGuard(frames int, rect image.Rectangle) *flickerGuard {
+	return &flickerGuard{
+		frames:  uint32(frames),
+		rect:    rect,
+		changed: make([]uint32, rect.Dx()*rect.Dy()),
+	}
+}
+
+// nextFrame starts a new frame. The caller must hold canvasMu for writing.
+func (f *flickerGuard) nextFrame() {
+	f.frame++
+}
+
+// allow reports whether (x, y) may change in the current frame and records
+// the change if so. The caller must hold canvasMu for writing.
+func (f *flickerGuard) allow(x, y int) bool {
+	if !(image.Point{x, y}.In(f.rect)) {
+		return true
+	}
+	i := (y-f.rect.Min.Y)*f.rect.Dx() + x - f.rect.Min.X
+
+	if last := f.changed[i]; last != 0 {
+		last--
+		if last != f.frame && f.frame-last < f.frames {
+			return false
+		}
+	}
+	f.changed[i] = f.frame + 1
+	return true
+}
+
+// resize forgets all changes and tracks rect from now on. The caller must
+// hold canvasMu for writing.
+func (f *flickerGuard) resize(rect image.Rectangle) {
+	f.rect = rect
+	f.changed = make([]uint32, rect.Dx()*rect.Dy())
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestFlickerGuard(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.flicker = newFlickerGuard(3, g.canvas.Rect)
+	c := connect(t, g)
+
+	// within a frame the last write wins
+	c.do("PX 1 1 ff0000", "PX 1 1 00ff00")
+	if got := g.Render().RGBAAt(1, 1); got != green {
+		t.Fatalf("pixel after its first frame = %v, want %v", got, green)
+	}
+
+	// the next two frames can't change it, other pixels are unaffected
+	for frame := 1; frame < 3; frame++ {
+		c.do("PX 1 1 0000ff", "PX 2 2 0000ff")
+		canvas := g.Render()
+		if got := canvas.RGBAAt(1, 1); got != green {
+			t.Errorf("frame %d: pixel changed too often = %v, want its earlier %v", frame, got, green)
+		}
+		if got := canvas.RGBAAt(2, 2); got != blue {
+			t.Errorf("frame %d: other pixel = %v, want %v", frame, got, blue)
+		}
+	}
+
+	c.do("PX 1 1 0000ff")
+	if got := g.Render().RGBAAt(1, 1); got != blue {
+		t.Errorf("pixel after the cooldown = %v, want %v", got, blue)
+	}
+}
+
+func TestFlickerGuardWithRenderTasks(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.flicker = newFlickerGuard(2, g.canvas.Rect)
+
+	// a task and a queued write in the same frame count as one change
+	g.enqueue(PixelUpdate{x: 1, y: 1, color: green})
+	g.queueTask(func() { g.applyUpdate(PixelUpdate{x: 1, y: 1, color: red}) })
+	if got := g.Render().RGBAAt(1, 1); got != red {
+		t.Errorf("pixel = %v, want the task's write %v", got, red)
+	}
+	// (1, 1) of the 4 pixel wide canvas
+	if got := g.flicker.changed[1*4+1]; got != g.flicker.frame+1 {
+		t.Errorf("pixel recorded as changed in frame %d, want %d", got-1, g.flicker.frame)
+	}
+}
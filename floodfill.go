@@ -0,0 +1,126 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// maxFloodPixels bounds the pixels a single FLOODFILL may change, so a fill
+// keeps its frame short and its visited set small. A fill hitting the limit
+// stops short.
+const maxFloodPixels = 1 << 18
+
+// floodFill fills the region around (x, y) that has the same color as
+// (x, y) with c, all in one frame. The region is collected on the render
+// goroutine, then each of its pixels is admitted like a PX command and
+// drawn, or held while the connection is holding. The connection waits for
+// the fill, so the render goroutine has its state to itself meanwhile.
+func (g *Game) floodFill(state *connState, x, y int, c color.RGBA) {
+	filled := make(chan struct{})
+	g.queueTask(func() {
+		defer close(filled)
+		g.fillRegion(state, x, y, c)
+	})
+	select {
+	case <-filled:
+	case <-g.done:
+	}
+}
+
+// fillRegion does the work of floodFill. The caller must hold canvasMu for
+// writing.
+func (g *Game) fillRegion(state *connState, x, y int, c color.RGBA) {
+	start := image.Point{x, y}
+	if !start.In(g.canvas.Rect) {
+		return
+	}
+	target := g.canvas.RGBAAt(x, y)
+	if target == c {
+		return
+	}
+
+	// collect the region first, so translucent fills that blend into the
+	// target color can't make it grow forever
+	spans := floodSpans(g.canvas.Rect, start, func(p image.Point) bool {
+		return g.canvas.RGBAAt(p.X, p.Y) == target
+	})
+
+	n := 0
+	for _, span := range spans {
+		for x := span.Min.X; x < span.Max.X; x++ {
+			update, ok := g.admitPixel(state, x, span.Min.Y, c)
+			if !ok {
+				continue
+			}
+			if state.holding {
+				if len(state.held) >= maxHeldPixels {
+					g.stats.dropped.Add(1)
+					continue
+				}
+				state.held = append(state.held, update)
+				continue
+			}
+			g.applyUpdate(update)
+			n++
+		}
+	}
+	g.stats.pixels.Add(uint64(n))
+}
+
+// floodSpans returns the horizontal runs of the region of pixels connected
+// to start for which match holds, using a scanline fill. It stops after
+// maxFloodPixels pixels, which also bounds the visited set, whatever the
+// size of bounds.
+func floodSpans(bounds image.Rectangle, start image.Point, match func(image.Point) bool) []image.Rectangle {
+	visited := make(map[image.Point]struct{})
+	seen := func(p image.Point) bool {
+		_, ok := visited[p]
+		return ok
+	}
+	mark := func(p image.Point) {
+		visited[p] = struct{}{}
+	}
+
+	var spans []image.Rectangle
+	filled := 0
+	seeds := []image.Point{start}
+	for len(seeds) > 0 && filled < maxFloodPixels {
+		p := seeds[len(seeds)-1]
+		seeds = seeds[:len(seeds)-1]
+		if seen(p) || !match(p) {
+			continue
+		}
+
+		// widen the seed into the longest matching run of its row
+		left, right := p.X, p.X+1
+		for left > bounds.Min.X && !seen(image.Point{left - 1, p.Y}) && match(image.Point{left - 1, p.Y}) {
+			left--
+		}
+		for right < bounds.Max.X && !seen(image.Point{right, p.Y}) && match(image.Point{right, p.Y}) {
+			right++
+		}
+		right = min(right, left+maxFloodPixels-filled)
+		for x := left; x < right; x++ {
+			mark(image.Point{x, p.Y})
+		}
+		spans = append(spans, image.Rect(left, p.Y, right, p.Y+1))
+		filled += right - left
+
+		// seed every run touching this one in the rows above and below
+		for _, y := range [2]int{p.Y - 1, p.Y + 1} {
+			if y < bounds.Min.Y || y >= bounds.Max.Y {
+				continue
+			}
+			inRun := false
+			for x := left; x < right; x++ {
+				q := image.Point{x, y}
+				ok := !seen(q) && match(q)
+				if ok && !inRun {
+					seeds = append(seeds, q)
+				}
+				inRun = ok
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestFloodFill(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	// a 5x5 box outline from (2, 2) to (6, 6)
+	var outline []string
+	for i := 2; i <= 6; i++ {
+		outline = append(outline,
+			fmt.Sprintf("PX %d 2 ffffff", i), fmt.Sprintf("PX %d 6 ffffff", i),
+			fmt.Sprintf("PX 2 %d ffffff", i), fmt.Sprintf("PX 6 %d ffffff", i))
+	}
+	c.do(outline...)
+	g.Render()
+
+	// the fill runs in a render task, which the connection waits for
+	c.send("FLOODFILL 4 4 ff0000")
+	renderTask(t, g)
+	c.sync()
+	canvas := g.Render()
+	for y := 3; y <= 5; y++ {
+		for x := 3; x <= 5; x++ {
+			if got := canvas.RGBAAt(x, y); got != red {
+				t.Errorf("interior pixel (%d, %d) = %v, want %v", x, y, got, red)
+			}
+		}
+	}
+	for _, p := range [][2]int{{2, 4}, {0, 0}, {8, 8}} {
+		if got := canvas.RGBAAt(p[0], p[1]); got == red {
+			t.Errorf("pixel %v outside the region was filled", p)
+		}
+	}
+}
+
+func TestFloodFillCooldown(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.cooldowns = newCooldowns(time.Hour, 16)
+	c := connect(t, g)
+
+	c.send("FLOODFILL 0 0 ff0000")
+	renderTask(t, g)
+	c.sync()
+	canvas := g.Render()
+	drawn := 0
+	for i := 3; i < len(canvas.Pix); i += 4 {
+		if canvas.Pix[i] != 0 {
+			drawn++
+		}
+	}
+	if drawn != 1 {
+		t.Errorf("FLOODFILL during a cooldown drew %d pixels, want 1", drawn)
+	}
+}
+
+func TestFloodFillOneFrame(t *testing.T) {
+	// more pixels than the queues hold, all drawn in the fill's frame
+	g := newTestGame(t, 100, 100)
+	c := connect(t, g)
+
+	c.send("FLOODFILL 50 50 ff0000")
+	renderTask(t, g)
+	c.sync()
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			if got := g.canvas.RGBAAt(x, y); got != red {
+				t.Fatalf("pixel (%d, %d) = %v after the fill's frame, want %v", x, y, got, red)
+			}
+		}
+	}
+}
+
+func TestFloodFillHeld(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.send("HOLD", "FLOODFILL 0 0 ff0000")
+	renderTask(t, g)
+	c.sync()
+	if got := g.pixel(5, 5); got != (color.RGBA{}) {
+		t.Fatalf("held FLOODFILL drew %v before FLUSH", got)
+	}
+
+	c.send("FLUSH")
+	renderTask(t, g)
+	c.sync()
+	g.Render()
+	if got := g.pixel(5, 5); got != red {
+		t.Errorf("held FLOODFILL drew %v after FLUSH, want %v", got, red)
+	}
+}
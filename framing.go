@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// pbFrameSize is the length of a binary PB command: "PB", x and y as
+// little-endian uint16, then R, G, B and A.
+const pbFrameSize = 10
+
+// splitCommands is the bufio.SplitFunc for a connection. It yields
+// newline-terminated command lines without the newline or a trailing CR.
+// A command starting with "PB" is a fixed-length binary frame instead and
+// is yielded whole and unaltered, with binaryToken set, since its payload
+// may contain CR and LF bytes. While a command is reading a raw payload,
+// that is yielded unchanged, too. Lines longer than the connection's
+// maximum line length are dropped as a whole.
+func (s *connState) splitCommands(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if s.payloadLeft > 0 {
+		if len(data) == 0 {
+			if atEOF {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		n := min(int64(len(data)), s.payloadLeft)
+		s.payloadLeft -= n
+		return int(n), data[:n], nil
+	}
+
+	if s.discarding {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			// end of an overlong line
+			s.discarding = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+
+	if bytes.HasPrefix(data, []byte("PB")) {
+		if len(data) < pbFrameSize {
+			if atEOF {
+				return len(data), nil, nil
+			}
+			return 0, nil, nil
+		}
+		s.binaryToken = true
+		return pbFrameSize, data[:pbFrameSize], nil
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		if i >= s.maxLine {
+			// an overlong line that arrived in one read, e.g. into a
+			// larger buffer from readBuffers
+			return i + 1, nil, nil
+		}
+		return i + 1, bytes.TrimSuffix(data[:i], []byte("\r")), nil
+	}
+	if len(data) >= s.maxLine {
+		s.discarding = true
+		return len(data), nil, nil
+	}
+	if atEOF {
+		// an unterminated last line is ignored
+		return len(data), nil, nil
+	}
+	return 0, nil, nil
+}
+
+// payloadReader reads the n raw bytes following the current command line
+// through the connection's scanner.
+type payloadReader struct {
+	state   *connState
+	pending []byte
+}
+
+// payload returns a reader for the next n bytes of the connection, which
+// must be consumed completely before the next command is scanned.
+func (s *connState) payload(n int64) io.Reader {
+	s.payloadLeft = n
+	return &payloadReader{state: s}
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.state.payloadLeft == 0 {
+			return 0, io.EOF
+		}
+		if !r.state.scanner.Scan() {
+			if err := r.state.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.pending = r.state.scanner.Bytes()
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// encodeBinaryPixel returns a PX read reply in the PB frame format: "PB", x
+// and y as little-endian uint16, then the straight RGBA color of the
+// premultiplied c.
+func encodeBinaryPixel(x, y int, c color.RGBA) []byte {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	frame := make([]byte, pbFrameSize)
+	copy(frame, "PB")
+	binary.LittleEndian.PutUint16(frame[2:4], uint16(x))
+	binary.LittleEndian.PutUint16(frame[4:6], uint16(y))
+	frame[6], frame[7], frame[8], frame[9] = n.R, n.G, n.B, n.A
+	return frame
+}
+
+// readBuffers recycles the initial scanner buffers, so short-lived flood
+// connections don't allocate a fresh one each.
+var readBuffers sync.Pool
+
+// newScanner returns the scanner reading commands from the connection. Its
+// buffer is returned to readBuffers by releaseScanner.
+func (s *connState) newScanner(maxLine int) *bufio.Scanner {
+	size := min(maxLine, 64*1024)
+	buf, _ := readBuffers.Get().(*[]byte)
+	if buf == nil || cap(*buf) < size {
+		b := make([]byte, size)
+		buf = &b
+	}
+	s.readBuf = buf
+
+	s.maxLine = maxLine
+	s.scanner = bufio.NewScanner(s.conn)
+	s.scanner.Buffer((*buf)[:0], maxLine)
+	s.scanner.Split(s.splitCommands)
+	return s.scanner
+}
+
+// releaseScanner returns the scanner's buffer to the pool. The scanner must
+// not be used afterwards.
+func (s *connState) releaseScanner() {
+	if s.readBuf == nil {
+		return
+	}
+	// don't hand the rest of this client's data to the next connection
+	clear((*s.readBuf)[:cap(*s.readBuf)])
+	readBuffers.Put(s.readBuf)
+	s.readBuf = nil
+	s.scanner = nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image/color"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanPackets writes each packet separately to a connection and returns the
+// tokens its scanner yields.
+func scanPackets(t *testing.T, maxLine int, packets ...string) []string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		for _, packet := range packets {
+			client.Write([]byte(packet))
+		}
+		client.Close()
+	}()
+
+	state := &connState{conn: server}
+	scanner := state.newScanner(maxLine)
+	defer state.releaseScanner()
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, string(scanner.Bytes()))
+		state.binaryToken = false
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestSplitCommands(t *testing.T) {
+	long := strings.Repeat("A", 40)
+	tests := []struct {
+		name    string
+		packets []string
+		want    []string
+	}{
+		{"multi-line packet", []string{"PX 1 1 ff0000\nPX 2 2 00ff00\r\nSIZE\n"}, []string{"PX 1 1 ff0000", "PX 2 2 00ff00", "SIZE"}},
+		{"partial lines", []string{"PX 1 ", "1 ff", "0000\nSI", "ZE\n"}, []string{"PX 1 1 ff0000", "SIZE"}},
+		{"partial CRLF", []string{"SIZE\r", "\nHELP\n"}, []string{"SIZE", "HELP"}},
+		{"unterminated last line", []string{"SIZE\nPX 1 1"}, []string{"SIZE"}},
+		{"empty lines", []string{"\n\nSIZE\n"}, []string{"", "", "SIZE"}},
+		{"oversized line", []string{"SIZE\n" + long + "\nHELP\n"}, []string{"SIZE", "HELP"}},
+		{"oversized line across packets", []string{"SIZE\nPX " + long, long, "0000\nHELP\n"}, []string{"SIZE", "HELP"}},
+		{"line just short of the limit", []string{strings.Repeat("B", 31) + "\n"}, []string{strings.Repeat("B", 31)}},
+	}
+	for _, test := range tests {
+		got := scanPackets(t, 32, test.packets...)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("%s: tokens = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBinaryPixelWithNewlines(t *testing.T) {
+	g := newTestGame(t, 16, 16)
+	c := connect(t, g)
+
+	// x is 10 and y is 13, i.e. LF and CR, and so are the color bytes
+	frame := []byte{'P', 'B', 0x0a, 0, 0x0d, 0, 0x0d, 0x0a, 0x0d, 0xff}
+	c.conn.Write(append(frame, "PX 1 1 ff0000\n"...))
+	c.sync()
+
+	canvas := g.Render()
+	if got, want := canvas.RGBAAt(10, 13), (color.RGBA{0x0d, 0x0a, 0x0d, 0xff}); got != want {
+		t.Errorf("PB pixel = %v, want %v", got, want)
+	}
+	if got := canvas.RGBAAt(1, 1); got != red {
+		t.Errorf("PX after the PB frame = %v, want %v", got, red)
+	}
+}
+
+func TestSplitBinaryFrames(t *testing.T) {
+	frame := "PB\x0a\x00\x0d\x00\x0d\x0a\x0d\xff"
+	got := scanPackets(t, 32, "SIZE\n"+frame[:4], frame[4:]+frame+"HELP\n")
+	want := []string{"SIZE", frame, frame, "HELP"}
+	if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+		t.Errorf("tokens = %q, want %q", got, want)
+	}
+}
+
+func TestReleasedBufferCleared(t *testing.T) {
+	// a client that leaves a partial line behind
+	scanPackets(t, 64, "PX 1 1 ff0000\nSECRET PARTIAL")
+
+	buf, _ := readBuffers.Get().(*[]byte)
+	if buf == nil {
+		t.Skip("the pool dropped the buffer")
+	}
+	defer readBuffers.Put(buf)
+	if bytes.Contains((*buf)[:cap(*buf)], []byte("SECRET")) {
+		t.Error("a released buffer still holds the previous client's data")
+	}
+}
+
+// readerConn is a connection that reads from r.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// BenchmarkConnectionBuffers compares the allocations of a connection's
+// read buffer with and without readBuffers.
+func BenchmarkConnectionBuffers(b *testing.B) {
+	const maxLine = 10240
+	reader := bytes.NewReader(nil)
+	data := []byte("PX 1 1 ff0000\n")
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reader.Reset(data)
+			state := &connState{conn: readerConn{r: reader}}
+			scanner := state.newScanner(maxLine)
+			for scanner.Scan() {
+			}
+			state.releaseScanner()
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reader.Reset(data)
+			state := &connState{conn: readerConn{r: reader}, maxLine: maxLine}
+			scanner := bufio.NewScanner(state.conn)
+			scanner.Buffer(make([]byte, 0, maxLine), maxLine)
+			scanner.Split(state.splitCommands)
+			for scanner.Scan() {
+			}
+		}
+	})
+}
+
+func TestClientBinaryReplies(t *testing.T) {
+	g := newTestGame(t, 400, 4)
+	c := connect(t, g)
+	if got := c.do("CLIENT bot binary unknown"); len(got) != 1 || got[0] != "CLIENT bot binary" {
+		t.Fatalf("CLIENT replied %q", got)
+	}
+	c.do("PX 300 2 ff000080")
+	g.Render()
+
+	c.send("PX 300 2")
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame := make([]byte, pbFrameSize)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{'P', 'B', 0x2c, 0x01, 0x02, 0x00, 0xff, 0x00, 0x00, 0x80}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("PX read replied % x, want % x", frame, want)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// helpCommand documents a built-in command for HELP.
+type helpCommand struct {
+	Name        string `json:"name"`
+	Syntax      string `json:"syntax"`
+	Description string `json:"description"`
+	Admin       bool   `json:"admin,omitempty"`
+}
+
+// helpCommands lists the built-in commands in the order HELP shows them.
+var helpCommands = []helpCommand{
+	{"HELP", "HELP", "get this information page", false},
+	{"HELP", "HELP <lang>", "get this information page in another language, e.g. en or de", false},
+	{"HELP", "HELP json", "get the command list as a JSON array", false},
+	{"SIZE", "SIZE", "get the size of the canvas", false},
+	{"CLIENT", "CLIENT <name> [features]", "introduce the client; with the binary feature, PX reads reply with PB frames", false},
+	{"PING", "PING [token]", "get PONG and the token back, e.g. to measure the round trip time", false},
+	{"BOUNDS", "BOUNDS", "get the canvas as <x> <y> <w> <h> in this connection's coordinates, i.e. after OFFSET and SCALE", false},
+	{"LIMITS", "LIMITS", "get the limits in force for clients, like max-line and rate-limit, as name=value pairs; 0 means no limit", false},
+	{"QUEUE", "QUEUE", "get the queued pixel writes, the queue capacity and the estimated wait in milliseconds, e.g. to back off", false},
+	{"CONNS", "CONNS", "get the number of open connections from this connection's IP, including itself", false},
+	{"UPTIME", "UPTIME", "get the seconds since the server started and its start time", false},
+	{"PX", "PX <x> <y>", "get the color of pixel (x, y)", false},
+	{"PX", "PX <x> <y> <COLOR>", "set the color of pixel (x, y)", false},
+	{"PXCAS", "PXCAS <x> <y> <OLD> <NEW>", "set pixel (x, y) to NEW only if it is OLD, replying OK or FAIL", false},
+	{"PXAT", "PXAT <frame> <x> <y> <COLOR>", "set the color of pixel (x, y) when the display reaches the given frame", false},
+	{"PXFADE", "PXFADE <x> <y> <COLOR> <frames>", "change pixel (x, y) to COLOR gradually over the given number of frames", false},
+	{"FRAME", "FRAME", "get the number of the frame last drawn", false},
+	{"PXR", "PXR <dx> <dy> <COLOR>", "set the color of the pixel at (dx, dy) relative to the last pixel set", false},
+	{"PB", "PB<x><y><rgba>", "binary set: x and y as little-endian uint16, then 4 color bytes, no newline", false},
+	{"PXD", "PXD <n>", "set the pixels in the next n bytes: per pixel x and y distance to the last one as zigzag varints, then RGBA", false},
+	{"PXN", "PXN <fx> <fy> <COLOR>", "set the pixel at the normalized position (0..1, 0..1)", false},
+	{"STAMP", "STAMP <x> <y> <name>", "draw the named sprite with its top left corner at (x, y)", false},
+	{"BLEND", "BLEND <mode>", "combine following pixel writes with the canvas by normal, add, multiply, screen or max", false},
+	{"CIRCLE", "CIRCLE <x> <y> <r> <COLOR>", "draw the outline of a circle of radius r around (x, y)", false},
+	{"DISC", "DISC <x> <y> <r> <COLOR>", "draw a filled circle of radius r around (x, y)", false},
+	{"BRUSH", "BRUSH <x> <y> <r> <COLOR>", "dab a soft circle of radius r around (x, y), fading out towards the rim", false},
+	{"FLOODFILL", "FLOODFILL <x> <y> <COLOR>", "fill the area around (x, y) that has the same color as (x, y)", false},
+	{"HOLD", "HOLD", "collect following pixel writes without drawing them", false},
+	{"FLUSH", "FLUSH", "draw all collected pixel writes at once", false},
+	{"AVG", "AVG <x> <y> <r>", "get the average color of the square of radius r around (x, y)", false},
+	{"DOMINANT", "DOMINANT", "get the most frequent color of the canvas and the fraction of pixels that have it", false},
+	{"COLORS", "COLORS", "list the accepted COLOR forms and the -gray-mode", false},
+	{"COVERAGE", "COVERAGE", "get the fraction of the canvas that isn't background", false},
+	{"LOCK", "LOCK <x> <y> <w> <h>", "reserve a region for this connection for a while", false},
+	{"UNLOCK", "UNLOCK", "release the region reserved with LOCK", false},
+	{"OFFSET", "OFFSET <x> <y>", "sets an pixel offset for all following commands", false},
+	{"SCALE", "SCALE <fx> <fy>", "multiply the pixel coordinates of following commands before the offset is added, e.g. 0.5 0.5", false},
+	{"AUTH", "AUTH <token>", "unlock admin commands for this connection", false},
+	{"PAUSE", "PAUSE", "freeze the display", true},
+	{"RESUME", "RESUME", "unfreeze the display", true},
+	{"PRIORITY", "PRIORITY <x> <y> <w> <h>", "never drop pixel writes to this region when the queue is full", true},
+	{"PRIORITY", "PRIORITY clear", "remove all priority regions", true},
+	{"COPY", "COPY <x> <y> <w> <h> <dx> <dy>", "copy a region of the canvas so its top left corner ends up at (dx, dy)", true},
+	{"PROTECT", "PROTECT <x> <y> <w> <h>", "make a region read-only for everyone until UNPROTECT", true},
+	{"UNPROTECT", "UNPROTECT [<x> <y> <w> <h>]", "lift the protection of the regions overlapping the given one, or of all", true},
+	{"PALETTE", "PALETTE list", "get the named colors as name=rrggbb[aa] pairs", true},
+	{"PALETTE", "PALETTE set <name>=<COLOR> ...", "name colors, making the names usable as COLOR", true},
+	{"CHANSTATS", "CHANSTATS", "get a histogram of the queued pixel writes seen at the start of each frame, to size -queue-size", true},
+	{"CONFIG", "CONFIG", "get the version, startup size and all flags as JSON", true},
+	{"SHUTDOWN", "SHUTDOWN", "stop the server gracefully", true},
+	{"RESIZE", "RESIZE <w> <h>", "resize the canvas, keeping overlapping pixels", true},
+	{"STATE", "STATE", "get the raw RGBA canvas, preceded by a \"STATE <w> <h>\" line", false},
+	{"CHANGED", "CHANGED <frame>", "get the current frame and PX lines for the pixels changed after frame, or a STATE if there are too many", false},
+	{"SNAPSHOT", "SNAPSHOT base64", "get the canvas as a PNG in a single base64 line; large canvases give long lines", false},
+	{"STREAM", "STREAM on", "receive a binary delta frame whenever the canvas changes", false},
+	{"COMPRESS", "COMPRESS on", "deflate all following replies; can't be turned off again", false},
+	{"FILTER", "FILTER <x> <y> <w> <h> <op>", "apply invert, grayscale or brightness+-N to a region", true},
+	{"WHO", "WHO <x> <y>", "get the address that last set pixel (x, y) and when", true},
+	{"OWNERMAP", "OWNERMAP", "write a PNG coloring every pixel by its last writer to the snapshot directory", true},
+	{"PUTSTATE", "PUTSTATE <n>", "replace the canvas with the next n bytes of deflated RGBA", true},
+	{"REGIONSET", "REGIONSET <x> <y> <w> <h>", "replace a region with the next w*h*4 bytes of RGBA, row by row, all in the same frame", false},
+	{"EXPORTSVG", "EXPORTSVG", "write the canvas as an SVG of colored rects to the snapshot directory", true},
+}
+
+const helpFooter = `
+    COLOR:
+        Grayscale: ww          ("00"       black .. "ff"       white)
+        Short RGB: rgb         ("f00" is short for "ff0000")
+        RGB:       rrggbb      ("000000"   black .. "ffffff"   white)
+        RGBA:      rrggbbaa    (rgb with alpha)
+        HSV:       hsv:h,s,v   ("hsv:120,1,1" is green; hue in degrees, s and v 0..1)
+        Name:      name        (a color named with PALETTE set)
+
+Writes of one connection to the same pixel are drawn in the order they were
+sent. The order of writes from different connections is unspecified.
+
+Example:
+    "PX 420 69 ff\n"       -> set the color of pixel at (420, 69) to white
+    "PX 420 69 00ffff\n"   -> set the color of pixel at (420, 69) to cyan
+    "PX 420 69 ffff007f\n" -> blend the color of pixel at (420, 69) with yellow (alpha 127)
+`
+
+// helpTranslation holds the HELP page in another language. Descriptions are
+// looked up by the command's syntax, and every built-in command needs one. A
+// command added without translating it keeps the English description until
+// it is, which the tests point out.
+type helpTranslation struct {
+	welcome, commands, admin string
+	descriptions             map[string]string
+	footer                   string
+}
+
+// helpTranslations maps language codes to the HELP page in that language.
+var helpTranslations = map[string]helpTranslation{
+	"en": {
+		welcome:  "Welcome to Pixelflut!",
+		commands: "Commands:",
+		admin:    "(admin)",
+		footer:   helpFooter,
+	},
+}
+
+// helpText renders the human readable HELP page in the language lang,
+// falling back to English for languages without a translation and for
+// commands not translated yet.
+func helpText(lang string) string {
+	t, ok := helpTranslations[lang]
+	if !ok {
+		t = helpTranslations["en"]
+	}
+
+	var b strings.Builder
+	b.WriteString(t.welcome + "\n\n" + t.commands + "\n")
+	for _, c := range helpCommands {
+		description, ok := t.descriptions[c.Syntax]
+		if !ok {
+			description = c.Description
+		}
+		if c.Admin {
+			description += " " + t.admin
+		}
+		fmt.Fprintf(&b, "    %-19s -> %s\n", c.Syntax, description)
+	}
+	b.WriteString(t.footer)
+	return b.String()
+}
+
+// helpJSON renders the built-in and custom commands as a JSON array on a
+// single line. Custom commands carry no documentation, so only their names
+// are listed.
+func (g *Game) helpJSON() []byte {
+	commands := append([]helpCommand(nil), helpCommands...)
+
+	g.commands.mu.RLock()
+	custom := make([]string, 0, len(g.commands.handlers))
+	for name := range g.commands.handlers {
+		custom = append(custom, name)
+	}
+	g.commands.mu.RUnlock()
+	sort.Strings(custom)
+	for _, name := range custom {
+		commands = append(commands, helpCommand{Name: name, Syntax: name})
+	}
+
+	reply, _ := json.Marshal(commands)
+	return append(reply, '\n')
+}
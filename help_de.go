@@ -0,0 +1,90 @@
+package main
+
+func init() {
+	helpTranslations["de"] = helpTranslation{
+		welcome:  "Willkommen bei Pixelflut!",
+		commands: "Befehle:",
+		admin:    "(Admin)",
+		descriptions: map[string]string{
+			"HELP":                            "diese Infoseite abrufen",
+			"HELP <lang>":                     "diese Infoseite in einer anderen Sprache abrufen, z.B. en oder de",
+			"HELP json":                       "die Befehlsliste als JSON-Array abrufen",
+			"SIZE":                            "die Größe der Leinwand abrufen",
+			"CLIENT <name> [features]":        "den Client vorstellen; mit dem Feature binary antworten PX-Abfragen mit PB-Frames",
+			"PING [token]":                    "PONG und das Token zurückbekommen, z.B. um die Umlaufzeit zu messen",
+			"BOUNDS":                          "die Leinwand als <x> <y> <w> <h> in den Koordinaten dieser Verbindung abrufen, also nach OFFSET und SCALE",
+			"LIMITS":                          "die geltenden Grenzen für Clients, wie max-line und rate-limit, als name=wert-Paare abrufen; 0 heißt keine Grenze",
+			"QUEUE":                           "die wartenden Pixel, die Kapazität der Warteschlange und die geschätzte Wartezeit in Millisekunden abrufen, z.B. um langsamer zu senden",
+			"CONNS":                           "die Zahl der offenen Verbindungen von der IP dieser Verbindung abrufen, sie selbst eingeschlossen",
+			"UPTIME":                          "die Sekunden seit dem Serverstart und die Startzeit abrufen",
+			"PX <x> <y>":                      "die Farbe des Pixels (x, y) abrufen",
+			"PX <x> <y> <COLOR>":              "die Farbe des Pixels (x, y) setzen",
+			"PXCAS <x> <y> <OLD> <NEW>":       "das Pixel (x, y) nur auf NEW setzen, wenn es OLD ist, mit OK oder FAIL als Antwort",
+			"PXAT <frame> <x> <y> <COLOR>":    "die Farbe des Pixels (x, y) setzen, sobald die Anzeige das angegebene Frame erreicht",
+			"PXFADE <x> <y> <COLOR> <frames>": "das Pixel (x, y) über die angegebene Zahl von Frames allmählich zu COLOR überblenden",
+			"FRAME":                           "die Nummer des zuletzt gezeichneten Frames abrufen",
+			"PXR <dx> <dy> <COLOR>":           "die Farbe des Pixels bei (dx, dy) relativ zum zuletzt gesetzten Pixel setzen",
+			"PB<x><y><rgba>":                  "binär setzen: x und y als Little-Endian-uint16, dann 4 Farbbytes, ohne Zeilenumbruch",
+			"PXD <n>":                         "die Pixel in den nächsten n Bytes setzen: je Pixel der x- und y-Abstand zum vorigen als Zigzag-Varints, dann RGBA",
+			"PXN <fx> <fy> <COLOR>":           "das Pixel an der normierten Position (0..1, 0..1) setzen",
+			"STAMP <x> <y> <name>":            "das benannte Sprite mit der linken oberen Ecke bei (x, y) zeichnen",
+			"BLEND <mode>":                    "folgende Pixel mit der Leinwand per normal, add, multiply, screen oder max verrechnen",
+			"CIRCLE <x> <y> <r> <COLOR>":      "den Umriss eines Kreises mit Radius r um (x, y) zeichnen",
+			"DISC <x> <y> <r> <COLOR>":        "einen gefüllten Kreis mit Radius r um (x, y) zeichnen",
+			"BRUSH <x> <y> <r> <COLOR>":       "einen weichen Kreis mit Radius r um (x, y) tupfen, der zum Rand hin ausblendet",
+			"FLOODFILL <x> <y> <COLOR>":       "die Fläche um (x, y), die die Farbe von (x, y) hat, füllen",
+			"HOLD":                            "folgende Pixel sammeln, ohne sie zu zeichnen",
+			"FLUSH":                           "alle gesammelten Pixel auf einmal zeichnen",
+			"AVG <x> <y> <r>":                 "die Durchschnittsfarbe des Quadrats mit Radius r um (x, y) abrufen",
+			"DOMINANT":                        "die häufigste Farbe der Leinwand und den Anteil der Pixel, die sie haben, abrufen",
+			"COLORS":                          "die akzeptierten COLOR-Formen und den -gray-mode auflisten",
+			"COVERAGE":                        "den Anteil der Leinwand abrufen, der nicht Hintergrund ist",
+			"LOCK <x> <y> <w> <h>":            "einen Bereich eine Zeit lang für diese Verbindung reservieren",
+			"UNLOCK":                          "den mit LOCK reservierten Bereich freigeben",
+			"OFFSET <x> <y>":                  "einen Pixelversatz für alle folgenden Befehle setzen",
+			"SCALE <fx> <fy>":                 "die Pixelkoordinaten folgender Befehle multiplizieren, bevor der Versatz addiert wird, z.B. 0.5 0.5",
+			"AUTH <token>":                    "Admin-Befehle für diese Verbindung freischalten",
+			"PAUSE":                           "die Anzeige einfrieren",
+			"RESUME":                          "die Anzeige wieder auftauen",
+			"PRIORITY <x> <y> <w> <h>":        "Pixel in diesem Bereich nie verwerfen, wenn die Warteschlange voll ist",
+			"PRIORITY clear":                  "alle Prioritätsbereiche entfernen",
+			"COPY <x> <y> <w> <h> <dx> <dy>":  "einen Bereich der Leinwand so kopieren, dass seine linke obere Ecke bei (dx, dy) landet",
+			"PROTECT <x> <y> <w> <h>":         "einen Bereich für alle schreibschützen, bis UNPROTECT",
+			"UNPROTECT [<x> <y> <w> <h>]":     "den Schutz der Bereiche aufheben, die den angegebenen überlappen, oder aller",
+			"PALETTE list":                    "die benannten Farben als name=rrggbb[aa]-Paare abrufen",
+			"PALETTE set <name>=<COLOR> ...":  "Farben benennen, sodass die Namen als COLOR gehen",
+			"CHANSTATS":                       "ein Histogramm der zu Beginn jedes Frames wartenden Pixel abrufen, um -queue-size zu bemessen",
+			"CONFIG":                          "die Version, die Startgröße und alle Flags als JSON abrufen",
+			"SHUTDOWN":                        "den Server sauber beenden",
+			"RESIZE <w> <h>":                  "die Größe der Leinwand ändern, überlappende Pixel bleiben erhalten",
+			"STATE":                           "die Leinwand als rohes RGBA abrufen, nach einer Zeile \"STATE <w> <h>\"",
+			"CHANGED <frame>":                 "das aktuelle Frame und PX-Zeilen für die seit frame geänderten Pixel abrufen, oder ein STATE, wenn es zu viele sind",
+			"SNAPSHOT base64":                 "die Leinwand als PNG in einer einzigen Base64-Zeile abrufen; große Leinwände ergeben lange Zeilen",
+			"STREAM on":                       "bei jeder Änderung der Leinwand ein binäres Delta-Frame empfangen",
+			"COMPRESS on":                     "alle folgenden Antworten mit Deflate komprimieren; lässt sich nicht wieder abschalten",
+			"FILTER <x> <y> <w> <h> <op>":     "invert, grayscale oder brightness+-N auf einen Bereich anwenden",
+			"WHO <x> <y>":                     "die Adresse abrufen, die das Pixel (x, y) zuletzt gesetzt hat, und wann",
+			"OWNERMAP":                        "ein PNG, das jedes Pixel nach seinem letzten Schreiber färbt, ins Snapshot-Verzeichnis schreiben",
+			"PUTSTATE <n>":                    "die Leinwand durch die nächsten n Bytes Deflate-komprimiertes RGBA ersetzen",
+			"REGIONSET <x> <y> <w> <h>":       "einen Bereich durch die nächsten w*h*4 Bytes RGBA ersetzen, Zeile für Zeile, alles im selben Frame",
+			"EXPORTSVG":                       "die Leinwand als SVG aus farbigen Rechtecken ins Snapshot-Verzeichnis schreiben",
+		},
+		footer: `
+    COLOR:
+        Graustufe: ww          ("00"       schwarz .. "ff"       weiß)
+        Kurz-RGB:  rgb         ("f00" ist kurz für "ff0000")
+        RGB:       rrggbb      ("000000"   schwarz .. "ffffff"   weiß)
+        RGBA:      rrggbbaa    (rgb mit Alpha)
+        HSV:       hsv:h,s,v   ("hsv:120,1,1" ist grün; Farbton in Grad, s und v 0..1)
+        Name:      name        (eine mit PALETTE set benannte Farbe)
+
+Pixel, die eine Verbindung mehrmals setzt, werden in der gesendeten
+Reihenfolge gezeichnet. Zwischen Verbindungen ist die Reihenfolge offen.
+
+Beispiel:
+    "PX 420 69 ff\n"       -> das Pixel bei (420, 69) weiß färben
+    "PX 420 69 00ffff\n"   -> das Pixel bei (420, 69) cyan färben
+    "PX 420 69 ffff007f\n" -> das Pixel bei (420, 69) mit Gelb mischen (Alpha 127)
+`,
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHelpJSON(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.RegisterCommand("CUSTOM", func(args []string, w io.Writer, state *connState) error { return nil })
+	c := connect(t, g)
+
+	got := c.do("HELP json")
+	if len(got) != 1 {
+		t.Fatalf("HELP json replied %d lines, want 1", len(got))
+	}
+	var commands []helpCommand
+	if err := json.Unmarshal([]byte(got[0]), &commands); err != nil {
+		t.Fatal(err)
+	}
+	syntax := make(map[string]string)
+	for _, command := range commands {
+		syntax[command.Name] = command.Syntax
+	}
+	for _, name := range []string{"PX", "SIZE", "CUSTOM"} {
+		if _, ok := syntax[name]; !ok {
+			t.Errorf("HELP json doesn't list %s", name)
+		}
+	}
+	if syntax["SIZE"] != "SIZE" {
+		t.Errorf("SIZE syntax = %q", syntax["SIZE"])
+	}
+}
+
+func TestHelpLanguages(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	for _, test := range []struct {
+		line, want, welcome string
+	}{
+		{"HELP de", helpText("de"), "Willkommen bei Pixelflut!"},
+		{"HELP en", helpText("en"), "Welcome to Pixelflut!"},
+		{"HELP xx", helpText("en"), "Welcome to Pixelflut!"},
+	} {
+		got := c.do(test.line)
+		if len(got) == 0 || got[0] != test.welcome {
+			t.Errorf("%s starts with %q, want %q", test.line, got, test.welcome)
+			continue
+		}
+		if reply := strings.Join(got, "\n") + "\n"; reply != test.want {
+			t.Errorf("%s replied\n%s\nwant\n%s", test.line, reply, test.want)
+		}
+	}
+
+	if !strings.Contains(helpText("de"), "die Größe der Leinwand abrufen") {
+		t.Error("HELP de doesn't translate SIZE")
+	}
+
+	g.lang = "de"
+	if got := c.do("HELP"); len(got) == 0 || got[0] != "Willkommen bei Pixelflut!" {
+		t.Errorf("HELP with -lang de starts with %q", got)
+	}
+}
+
+func TestHelpTranslationsComplete(t *testing.T) {
+	for lang, translation := range helpTranslations {
+		if lang == "en" {
+			continue
+		}
+		for _, c := range helpCommands {
+			if _, ok := translation.descriptions[c.Syntax]; !ok {
+				t.Errorf("HELP %s doesn't translate %q", lang, c.Syntax)
+			}
+		}
+		for syntax := range translation.descriptions {
+			if !slices.ContainsFunc(helpCommands, func(c helpCommand) bool { return c.Syntax == syntax }) {
+				t.Errorf("HELP %s translates %q, which isn't a command", lang, syntax)
+			}
+		}
+	}
+}
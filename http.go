@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// status is the JSON document pushed to /events subscribers.
+type status struct {
+	PixelsPerSec float64 `json:"pixels_per_sec"`
+	Connections  int64   `json:"connections"`
+	Coverage     float64 `json:"coverage"`
+}
+
+// startHTTPServer serves the HTTP status endpoints on addr until shutdown.
+func (g *Game) startHTTPServer(addr string, eventsInterval time.Duration) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		g.serveEvents(w, r, eventsInterval)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-g.done
+		server.Close()
+	}()
+
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveEvents streams the server status as Server-Sent Events, one event
+// per interval.
+func (g *Game) serveEvents(w http.ResponseWriter, r *http.Request, interval time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		event, err := json.Marshal(status{
+			PixelsPerSec: g.stats.pixelsPerSec(),
+			Connections:  g.stats.connections.Load(),
+			Coverage:     g.coverage(),
+		})
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-g.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux && ledmatrix
+
+package main
+
+import (
+	"image/color"
+	"os"
+)
+
+// spiChunk is the largest write the spidev driver accepts by default.
+const spiChunk = 4096
+
+// ledMatrix drives a matrix of APA102 (DotStar) LEDs chained on a spidev
+// device, e.g. /dev/spidev0.0. The chain starts at the top left corner and
+// runs row by row, every other row right to left if serpentine.
+type ledMatrix struct {
+	f             *os.File
+	width, height int
+	serpentine    bool
+
+	// frame is the whole SPI transfer: the start frame, one 4 byte frame
+	// per LED, then the end frame
+	frame []byte
+}
+
+// openLEDMatrix opens the spidev device at path for a matrix of
+// width x height LEDs.
+func openLEDMatrix(path string, width, height int, serpentine bool) (*ledMatrix, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	n := width * height
+	// the end frame needs at least n/2 more clock edges to push the data
+	// through the chain
+	frame := make([]byte, 4+n*4+n/16+1)
+	for i := 0; i < n; i++ {
+		frame[4+i*4] = 0xe0 | 31 // full global brightness
+	}
+	for i := 4 + n*4; i < len(frame); i++ {
+		frame[i] = 0xff
+	}
+	return &ledMatrix{f: f, width: width, height: height, serpentine: serpentine, frame: frame}, nil
+}
+
+// Set stores c for the LED at (x, y). Premultiplied colors are exactly what
+// the LEDs show on their black background.
+func (m *ledMatrix) Set(x, y int, c color.RGBA) {
+	if x < 0 || y < 0 || x >= m.width || y >= m.height {
+		return
+	}
+	if m.serpentine && y%2 == 1 {
+		x = m.width - 1 - x
+	}
+	i := 4 + (y*m.width+x)*4
+	m.frame[i+1], m.frame[i+2], m.frame[i+3] = c.B, c.G, c.R
+}
+
+// Flush sends the whole chain. The LEDs latch on the clock alone, so
+// splitting the transfer into driver-sized writes is fine.
+func (m *ledMatrix) Flush() {
+	for frame := m.frame; len(frame) > 0; {
+		n, err := m.f.Write(frame[:min(len(frame), spiChunk)])
+		if err != nil {
+			return
+		}
+		frame = frame[n:]
+	}
+}
+
+func (m *ledMatrix) close() error {
+	return m.f.Close()
+}
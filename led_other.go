@@ -0,0 +1,21 @@
+//go:build !linux || !ledmatrix
+
+package main
+
+import (
+	"errors"
+	"image/color"
+)
+
+// ledMatrix is only available in Linux builds with the ledmatrix tag.
+type ledMatrix struct{}
+
+func openLEDMatrix(path string, width, height int, serpentine bool) (*ledMatrix, error) {
+	return nil, errors.New("LED matrix output needs a Linux build with -tags ledmatrix")
+}
+
+func (m *ledMatrix) Set(x, y int, c color.RGBA) {}
+
+func (m *ledMatrix) Flush() {}
+
+func (m *ledMatrix) close() error { return nil }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// regionLock reserves a canvas region for the connection holding it.
+type regionLock struct {
+	rect    image.Rectangle
+	holder  *connState
+	expires time.Time
+}
+
+// regionLocks tracks the regions locked with LOCK. Each connection holds at
+// most one lock, which expires after a while so an abandoned lock can't
+// block a region forever.
+//
+// Like any other pixel write, a command drawing several pixels checks the
+// locks for every one of them.
+type regionLocks struct {
+	mu    sync.RWMutex
+	locks []regionLock
+	// count mirrors len(locks) so the write path can skip the mutex
+	// while nothing is locked
+	count atomic.Int32
+}
+
+var (
+	errRegionLocked = errors.New("region is locked")
+	errTooManyLocks = errors.New("too many locks from this address")
+)
+
+// lock reserves r for holder until expires, replacing holder's previous
+// lock. It fails if r overlaps a region locked by someone else, or if other
+// connections from holder's IP already hold maxPerIP locks. maxPerIP 0 means
+// no limit.
+func (l *regionLocks) lock(r image.Rectangle, holder *connState, expires time.Time, maxPerIP int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(time.Now())
+	sameIP := 0
+	for _, other := range l.locks {
+		if other.holder == holder {
+			continue
+		}
+		if other.rect.Overlaps(r) {
+			return errRegionLocked
+		}
+		if other.holder.ip == holder.ip {
+			sameIP++
+		}
+	}
+	if maxPerIP > 0 && sameIP >= maxPerIP {
+		return errTooManyLocks
+	}
+	l.removeLocked(holder)
+	l.locks = append(l.locks, regionLock{rect: r, holder: holder, expires: expires})
+	l.count.Store(int32(len(l.locks)))
+	return nil
+}
+
+// unlock releases holder's lock, if it has one.
+func (l *regionLocks) unlock(holder *connState) {
+	if l.count.Load() == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removeLocked(holder)
+	l.count.Store(int32(len(l.locks)))
+}
+
+// allows reports whether writer may write the pixel at p.
+func (l *regionLocks) allows(writer *connState, p image.Point) bool {
+	if l.count.Load() == 0 {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+	for _, lock := range l.locks {
+		if lock.holder != writer && p.In(lock.rect) && now.Before(lock.expires) {
+			return false
+		}
+	}
+	return true
+}
+
+// prune drops expired locks. The caller must hold mu for writing.
+func (l *regionLocks) prune(now time.Time) {
+	kept := l.locks[:0]
+	for _, lock := range l.locks {
+		if now.Before(lock.expires) {
+			kept = append(kept, lock)
+		}
+	}
+	l.locks = kept
+}
+
+// removeLocked drops holder's lock. The caller must hold mu for writing.
+func (l *regionLocks) removeLocked(holder *connState) {
+	for i, lock := range l.locks {
+		if lock.holder == holder {
+			l.locks = append(l.locks[:i], l.locks[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestLockBlocksOthers(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	holder, other := connect(t, g), connect(t, g)
+
+	if got := holder.do("LOCK 0 0 5 5"); len(got) != 1 || got[0] != "LOCK OK 30" {
+		t.Fatalf("LOCK replied %q", got)
+	}
+	other.do("PX 1 1 ff0000", "PX 6 6 ff0000")
+	holder.do("PX 2 2 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("write into someone else's lock = %v, want it rejected", got)
+	}
+	if got := canvas.RGBAAt(6, 6); got != red {
+		t.Errorf("write outside the lock = %v, want %v", got, red)
+	}
+	if got := canvas.RGBAAt(2, 2); got != green {
+		t.Errorf("holder's write = %v, want %v", got, green)
+	}
+	if got := other.do("LOCK 4 4 2 2"); len(got) != 1 || got[0] != "ERROR region is locked" {
+		t.Errorf("overlapping LOCK replied %q", got)
+	}
+}
+
+func TestLockArea(t *testing.T) {
+	g := newTestGame(t, 100, 100)
+	g.maxLockArea = 100
+	c := connect(t, g)
+
+	if got := c.do("LOCK 0 0 11 10"); len(got) != 1 || got[0] != "ERROR a lock may cover at most 100 pixels" {
+		t.Errorf("oversized LOCK replied %q", got)
+	}
+	// only the part on the canvas counts
+	if got := c.do("LOCK 95 95 10 20"); len(got) != 1 || got[0] != "LOCK OK 30" {
+		t.Errorf("LOCK across the edge replied %q", got)
+	}
+	if got := c.do("AUTH secret", "LOCK 0 0 50 50"); len(got) != 2 || got[1] != "LOCK OK 30" {
+		t.Errorf("oversized LOCK of an admin replied %q", got)
+	}
+}
+
+func TestLocksPerIP(t *testing.T) {
+	g := newTestGame(t, 100, 100)
+	g.maxLocksPerIP = 2
+	a, b, c := connect(t, g), connect(t, g), connect(t, g)
+
+	a.do("LOCK 0 0 5 5")
+	b.do("LOCK 10 10 5 5")
+	if got := c.do("LOCK 20 20 5 5"); len(got) != 1 || got[0] != "ERROR too many locks from this address" {
+		t.Errorf("third LOCK from one address replied %q", got)
+	}
+	// replacing one's own lock doesn't count against the limit
+	if got := b.do("LOCK 30 30 5 5"); len(got) != 1 || got[0] != "LOCK OK 30" {
+		t.Errorf("moving a lock replied %q", got)
+	}
+	a.do("UNLOCK")
+	if got := c.do("LOCK 20 20 5 5"); len(got) != 1 || got[0] != "LOCK OK 30" {
+		t.Errorf("LOCK after UNLOCK replied %q", got)
+	}
+}
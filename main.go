@@ -1,265 +1,2049 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"image"
 	"image/color"
 	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// version is reported in the connection banner. Release builds set it with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
 type Game struct {
-	debug      bool
-	once       bool
-	lastScreen *ebiten.Image
+	debug     bool
+	startTime time.Time
+	// clock times the animation, -max-runtime and -min-activity
+	clock clock
+
+	// canvas is the authoritative pixel state. It is only written on the
+	// render goroutine; everyone else reads it while holding canvasMu.
+	canvasMu sync.RWMutex
+	canvas   *image.RGBA
+	// window is the display while the window is open, set by the first Draw
+	window *windowDisplay
+	// canvasSize mirrors the canvas dimensions as width<<32 | height, so
+	// pixel writes can be bounds checked without taking canvasMu
+	canvasSize atomic.Uint64
+
+	// dirty bounds the canvas pixels changed since the display was last
+	// updated. It is only used on the render goroutine.
+	dirty image.Rectangle
+
+	// frameNumber counts the frames drawn, scheduled holds the PXAT
+	// updates for the coming ones
+	frameNumber atomic.Uint64
+	scheduled   pixelSchedule
+	// fades holds the running PXFADEs by pixel. It is only used on the
+	// render goroutine.
+	fades     map[image.Point]*pixelFade
+	fadeQueue fadeQueue
+
+	pixelUpdates  []*pixelQueue
+	queueDepths   depthHistogram
+	backlogPolicy backlogPolicy
+	renderTasks   chan func()
+
+	config Config
+
+	adminToken string
+	banner     bool
+	lang       string
+	maxLine    int
+	streamFPS  int
+	paused     atomic.Bool
+
+	// connStats logs a summary of every connection when it closes
+	connStats bool
+
+	// firstLineTimeout is how long a new connection may take to send its
+	// first complete command, zero disables the limit
+	firstLineTimeout time.Duration
+
+	// minActivity is the number of commands per activityWindow below which
+	// a connection is closed, zero disables the check
+	minActivity int64
+
+	// slowThreshold is the handling time above which a command, or the
+	// sending of a reply, is logged; zero disables the check
+	slowThreshold time.Duration
+
+	// strict makes the server reply with errors for rejected commands
+	// instead of dropping them silently
+	strict bool
+
+	// readOnly rejects every pixel write
+	readOnly bool
+
+	// limiters is nil unless per-client rate limiting is enabled
+	limiters *rateLimiters
+	// cooldowns is nil unless the collaborative cooldown mode is enabled
+	cooldowns *cooldowns
+
+	stats   stats
+	overlay atomic.Bool
+
+	integerScale bool
+	linearBlend  bool
+	// rotate is the number of clockwise quarter turns of the display; the
+	// canvas and its coordinates are never rotated
+	rotate int
+	// grayGamma interprets grayscale colors as linear luminance
+	grayGamma  bool
+	readFormat readFormat
+
+	// adaptive is nil unless the display refresh rate drops under load
+	adaptive *adaptiveRefresh
+
+	// background is nil unless something is shown beneath transparent
+	// pixels
+	background *background
+
+	// bloom is nil unless the glow display mode is enabled
+	bloom *bloom
+
+	// owners is nil unless pixel owner tracking is enabled
+	owners *ownerTracker
+
+	// changes is nil unless pixel changes are tracked for CHANGED
+	changes *changeTracker
+
+	// flicker is nil unless pixels may only change every few frames
+	flicker *flickerGuard
+
+	// mirror is nil unless pixel writes are forwarded to another server
+	mirror *mirror
+
+	// display is nil unless the canvas is shown on a device like a
+	// framebuffer instead of a window
+	display Display
+
+	commands commandRegistry
+
+	// conns counts the open connections per client IP for CONNS
+	conns ipConns
+
+	locks        regionLocks
+	lockDuration time.Duration
+	// maxLockArea and maxLocksPerIP bound the locks of clients that haven't
+	// authenticated; 0 means no limit
+	maxLockArea   int
+	maxLocksPerIP int
+
+	// writes to priority regions are never dropped by the backlog policy,
+	// writes to protected regions always are
+	priority  regionSet
+	protected regionSet
+
+	// palette holds the color names set with PALETTE
+	palette palette
+
+	// snapshotDir is where exported images are written
+	snapshotDir string
+	// snapshotOnShutdown makes shutdown save a snapshot first, whatever
+	// triggered it
+	snapshotOnShutdown bool
+
+	// sprites are loaded at startup and read-only afterwards
+	sprites map[string]*image.RGBA
+
+	access accessList
+
+	listenersMu sync.Mutex
+	listeners   []net.Listener
+
+	// done is closed on shutdown
+	done         chan struct{}
+	shutdownOnce sync.Once
+}
+
+// connState holds the per-connection protocol state.
+type connState struct {
+	conn    net.Conn
+	ip      string
+	ownerID uint32
+
+	// framing state of the scanner, see splitCommands
+	maxLine     int
+	discarding  bool
+	binaryToken bool
+	payloadLeft int64
+	scanner     *bufio.Scanner
+	readBuf     *[]byte
+	out         chan reply
+	authed      bool
+	// unlimited connections, like the one replaying the -animation, skip
+	// -cooldown and -rate-limit
+	unlimited bool
+	// shutdownRequested is set by SHUTDOWN; the server shuts down once the
+	// connection has sent its replies
+	shutdownRequested bool
+
+	// client and binaryReplies are declared with CLIENT
+	client        string
+	binaryReplies bool
+
+	// compressed is set once replies are deflate-compressed
+	compressed bool
+	streaming  bool
+
+	// done is closed when the connection ends; background workers writing
+	// to the connection watch it and are waited for before out is closed
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	offsetX, offsetY int
+	// activity counts the commands in the current activityWindow, see
+	// -min-activity
+	activity atomic.Int64
+	// scaleX and scaleY multiply the client's pixel coordinates before the
+	// offset is added, see SCALE; scaled is false while both are 1
+	scaleX, scaleY float64
+	scaled         bool
+	// blend is applied to the connection's pixel writes, see BLEND
+	blend blendMode
+	// lastX and lastY are the canvas coordinates of the last pixel set,
+	// used as the origin for PXR
+	lastX, lastY int
+
+	// while holding, pixel writes collect in held and PXFADEs in
+	// heldFades until FLUSH
+	holding   bool
+	held      []PixelUpdate
+	heldFades []queuedFade
+
+	// taskFence is closed once the render tasks of the last command drawn
+	// by one ran, or the fades it sent started; the next pixel write waits
+	// for it, see queueFor
+	taskFence <-chan struct{}
+
+	// counters for the summary logged on disconnect; only the connection's
+	// own goroutine updates them
+	commands, pixelWrites, reads, errors int
+}
+
+// maxHeldPixels bounds the writes a single connection may hold back.
+const maxHeldPixels = 1 << 20
+
+// outQueueSize is the number of replies that may be waiting to be sent to a
+// single client before it is disconnected as a slow consumer.
+const outQueueSize = 1024
+
+// reply is an entry of a connection's reply queue.
+type reply struct {
+	b []byte
+	// startCompression switches the rest of the reply stream to deflate,
+	// b is not used then
+	startCompression bool
+}
+
+// write queues a reply for the connection's writer goroutine, so a client
+// that is slow to read its replies doesn't stall the handling of its
+// commands. A client that lets the queue overflow is disconnected.
+func (s *connState) write(b []byte) {
+	if bytes.HasPrefix(b, []byte("ERROR")) {
+		// errors are only ever replied by the connection's goroutine
+		s.errors++
+	}
+	s.queue(reply{b: b})
+}
+
+// startCompression switches the replies queued after it to deflate.
+func (s *connState) startCompression() {
+	s.queue(reply{startCompression: true})
+}
+
+func (s *connState) queue(r reply) {
+	select {
+	case s.out <- r:
+	default:
+		s.conn.Close()
+	}
+}
+
+// writeLoop sends queued replies until out is closed or a write fails.
+// Replies are buffered and flushed whenever the queue runs empty, so a burst
+// of replies costs few syscalls while a lone reply still goes out at once.
+// Sending a reply that takes longer than slowThreshold, because the client
+// reads slowly, is logged; zero disables the check.
+func (s *connState) writeLoop(done chan<- struct{}, slowThreshold time.Duration) {
+	defer close(done)
+
+	buffered := bufio.NewWriterSize(s.conn, 32*1024)
+	var w io.Writer = buffered
+	var compressor *flate.Writer
+
+	for r := range s.out {
+		if r.startCompression {
+			if compressor == nil {
+				// the plain text acknowledgement goes out right away
+				if err := buffered.Flush(); err != nil {
+					s.conn.Close()
+					return
+				}
+				compressor, _ = flate.NewWriter(buffered, flate.DefaultCompression)
+				w = compressor
+			}
+			continue
+		}
 
-	windowWidth  int
-	windowHeight int
+		var start time.Time
+		if slowThreshold > 0 {
+			start = time.Now()
+		}
+		_, err := w.Write(r.b)
+		if err == nil && len(s.out) == 0 {
+			// nothing else to send right now, hand the client what we have
+			if compressor != nil {
+				err = compressor.Flush()
+			}
+			if err == nil {
+				err = buffered.Flush()
+			}
+		}
+		if slowThreshold > 0 {
+			if elapsed := time.Since(start); elapsed > slowThreshold {
+				log.Printf("Slow reply to %s took %v: %.64q", s.conn.RemoteAddr(), elapsed, r.b)
+			}
+		}
+		if err != nil {
+			// unblock the reader, further replies are dropped
+			s.conn.Close()
+			return
+		}
+	}
 
-	pixelUpdates chan PixelUpdate
+	if compressor != nil {
+		compressor.Close()
+	}
+	buffered.Flush()
 }
 
 type PixelUpdate struct {
 	x     int32
 	y     int32
 	color color.RGBA
+	owner uint32
+	blend blendMode
+}
+
+// requireAuth reports whether the connection may run admin commands,
+// replying with an error if it may not.
+func (s *connState) requireAuth() bool {
+	if !s.authed {
+		s.write([]byte("ERROR unauthorized\n"))
+	}
+	return s.authed
 }
 
 func (g *Game) Update() error {
+	select {
+	case <-g.done:
+		return ebiten.Termination
+	default:
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		g.setPaused(!g.paused.Load())
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		g.overlay.Store(!g.overlay.Load())
+	}
 	return nil
 }
 
+// setPaused freezes or unfreezes the display. While paused, Draw stops
+// draining pixelUpdates, so writes are buffered until the queues are full.
+func (g *Game) setPaused(paused bool) {
+	g.paused.Store(paused)
+	if paused {
+		log.Println("Rendering paused")
+	} else {
+		log.Println("Rendering resumed")
+	}
+}
+
 func (g *Game) Draw(screen *ebiten.Image) {
 	if g.debug {
 		defer log.Println("Screen updated")
 	}
 
-	if !g.once {
-		g.once = true
+	if g.window == nil {
+		// only ebiten calls Draw, so the window is open
+		g.window = &windowDisplay{}
+		g.display = g.window
+	}
+	if !g.paused.Load() {
+		if ebiten.IsKeyPressed(ebiten.KeyC) {
+			g.clear()
+		}
+		g.applyPending()
+		if g.adaptive == nil || g.window.frame == nil || g.adaptive.shouldUpload(time.Now(), g.stats.pixelsPerSec()) {
+			g.updateWindow()
+		}
+	}
+	frame := g.window.frame
+	if frame == nil {
+		// paused before the first frame, there is nothing to show yet
+		return
+	}
 
-		g.lastScreen = ebiten.NewImage(screen.Bounds().Dx(), screen.Bounds().Dy())
+	geoM := g.rotation(frame.Bounds().Dx(), frame.Bounds().Dy())
+	if g.integerScale {
+		// crisp pixels: scale by a whole factor and letterbox the rest
+		width, height := g.displaySize(frame.Bounds().Dx(), frame.Bounds().Dy())
+		scale := integerScale(screen.Bounds().Dx(), screen.Bounds().Dy(), width, height)
+		geoM.Scale(float64(scale), float64(scale))
+		geoM.Translate(
+			float64((screen.Bounds().Dx()-width*scale)/2),
+			float64((screen.Bounds().Dy()-height*scale)/2),
+		)
 	}
 
-	if ebiten.IsKeyPressed(ebiten.KeyC) {
-		screen.Fill(color.RGBA{0, 0, 0, 255})
-	} else {
-		screen.DrawImage(g.lastScreen, nil)
+	if g.background != nil {
+		g.background.draw(screen, frame.Bounds().Dx(), frame.Bounds().Dy(), geoM)
+	}
+	screen.DrawImage(frame, &ebiten.DrawImageOptions{GeoM: geoM})
+	if g.bloom != nil {
+		g.bloom.draw(screen, frame, geoM)
 	}
 
-	for {
-		select {
-		case update := <-g.pixelUpdates:
-			screen.Set(int(update.x), int(update.y), update.color)
-		default:
-			g.lastScreen.DrawImage(screen, nil)
-			return
-		}
+	g.stats.samplePixelRate(time.Now())
+	if g.overlay.Load() {
+		// drawn onto the screen only, so it never ends up in the canvas
+		ebitenutil.DebugPrint(screen, fmt.Sprintf(
+			"FPS: %.1f\nConnections: %d\nPixels/s: %.0f\nDropped: %d\nQueue: %d",
+			ebiten.ActualFPS(), g.stats.connections.Load(), g.stats.pixelsPerSec(),
+			g.stats.dropped.Load(), g.queuedUpdates(),
+		))
 	}
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-	return outsideWidth, outsideHeight
+	if g.integerScale {
+		// Draw does the scaling itself
+		return outsideWidth, outsideHeight
+	}
+	return g.displaySize(g.size())
+}
+
+// displaySize returns the size of a canvas of the given size on the display,
+// i.e. after rotation.
+func (g *Game) displaySize(width, height int) (int, int) {
+	if g.rotate%2 == 1 {
+		return height, width
+	}
+	return width, height
+}
+
+// rotation returns the transformation turning a frame of the given size
+// clockwise by the -rotate quarter turns, with the result's top left corner
+// at the origin.
+func (g *Game) rotation(width, height int) ebiten.GeoM {
+	var geoM ebiten.GeoM
+	geoM.Rotate(float64(g.rotate) * math.Pi / 2)
+	switch g.rotate {
+	case 1:
+		geoM.Translate(float64(height), 0)
+	case 2:
+		geoM.Translate(float64(width), float64(height))
+	case 3:
+		geoM.Translate(0, float64(width))
+	}
+	return geoM
+}
+
+// integerScale returns the largest whole factor by which a canvas of the
+// given size fits into the window, but at least 1.
+func integerScale(windowWidth, windowHeight, canvasWidth, canvasHeight int) int {
+	return max(min(windowWidth/canvasWidth, windowHeight/canvasHeight), 1)
+}
+
+// newGame returns a game with a transparent canvas of the given size and
+// queues for queueSize pixel updates spread over shards. The other settings
+// start out at the flag defaults.
+func newGame(width, height, queueSize, shards int) *Game {
+	g := &Game{
+		startTime:     time.Now(),
+		clock:         systemClock{},
+		canvas:        image.NewRGBA(image.Rect(0, 0, width, height)),
+		pixelUpdates:  newPixelQueues(queueSize, shards),
+		renderTasks:   make(chan func(), 16),
+		done:          make(chan struct{}),
+		lockDuration:  30 * time.Second,
+		maxLockArea:   64 * 64,
+		maxLocksPerIP: 4,
+		snapshotDir:   "snapshots",
+		maxLine:       10240,
+		streamFPS:     30,
+	}
+	g.storeCanvasSize(g.canvas.Rect)
+	return g
 }
 
 func main() {
 	// parse command line arguments for port number, width and height
-	port := flag.Int("port", 1337, "port number")
+	port := flag.Int("port", 1337, "port number (0 disables the TCP listener)")
+	portRange := flag.String("ports", "", "also listen on every TCP port in this range, e.g. 1338-1341")
+	unixPath := flag.String("unix", "", "also listen on a Unix domain socket at this path")
 	width := flag.Int("width", 800, "width")
 	height := flag.Int("height", 600, "height")
 	debug := flag.Bool("debug", false, "debug mode")
+	testRender := flag.Bool("test-render", false, "render into an in-memory canvas instead of opening a window")
+	rateLimit := flag.Float64("rate-limit", 0, "maximum pixels per second per client IP (0 disables)")
+	rateLimitEntries := flag.Int("rate-limit-entries", 65536, "maximum number of client IPs tracked by the rate limiter and by the cooldowns")
+	strict := flag.Bool("strict", false, "reply with ERROR lines for rejected commands instead of dropping them silently")
+	cooldown := flag.Duration("cooldown", 0, "allow each client IP only one pixel per period, like r/place (0 disables)")
+	coverageInterval := flag.Duration("coverage-interval", 0, "log the canvas coverage at this interval (0 disables)")
+	queueSize := flag.Int("queue-size", 210000, "number of pixel updates that may wait for the next frame")
+	queueShards := flag.Int("queue-shards", 4, "number of queues the pixel updates are spread over by canvas area, to reduce contention between writers")
+	onBacklog := flag.String("on-backlog", "block", "what to do with pixel writes when the queue is full: block, drop-oldest or drop-newest")
+	maxMemory := flag.String("max-memory", "", "refuse to start if the canvas and its buffers alone would need more memory than this, e.g. 512M (empty disables the check)")
+	httpAddr := flag.String("http", "", "address for the HTTP status server, e.g. :8080 (empty disables it)")
+	eventsInterval := flag.Duration("events-interval", time.Second, "interval between status events on the HTTP /events stream")
+	lockDuration := flag.Duration("lock-duration", 30*time.Second, "how long a LOCK reserves a region")
+	maxLockArea := flag.Int("max-lock-area", 64*64, "maximum number of canvas pixels a LOCK may reserve, unless authenticated (0 for no limit)")
+	maxLocksPerIP := flag.Int("max-locks-per-ip", 4, "maximum number of LOCKs held by connections from one IP, unless authenticated (0 for no limit)")
+	overlay := flag.Bool("overlay", false, "show render statistics in the window (toggle with O)")
+	snapshotDir := flag.String("snapshot-dir", "snapshots", "directory exported images are written to")
+	autoSnapshotInterval := flag.Duration("auto-snapshot-interval", 0, "save a PNG of the canvas to the -snapshot-dir at this interval (0 disables)")
+	autoSnapshotKeep := flag.Int("auto-snapshot-keep", 10, "number of automatic snapshots to keep")
+	spritesDir := flag.String("sprites-dir", "", "directory of PNG sprites available to STAMP")
+	seed := flag.Int64("seed", 0, "seed for randomized features (0 picks a random seed)")
+	maxLine := flag.Int("max-line", 10240, "maximum length of a command line in bytes; longer lines are dropped")
+	streamFPS := flag.Int("stream-fps", 30, "maximum frames per second pushed to STREAM clients")
+	monitor := flag.Int("monitor", 0, "index of the monitor to open the window on (see -list-monitors)")
+	listMonitors := flag.Bool("list-monitors", false, "print the available monitors and exit")
+	pixelCooldownFrames := flag.Int("pixel-cooldown-frames", 0, "minimum number of frames between two changes of the same pixel (0 disables)")
+	animation := flag.String("animation", "", "replay this script of commands, each block starting with an @<milliseconds> line")
+	animationLoop := flag.Bool("animation-loop", true, "restart the -animation after its last block")
+	slideshow := flag.String("slideshow", "", "show the PNGs in this directory one after another, in the order of their names, instead of what clients draw")
+	slideshowInterval := flag.Duration("slideshow-interval", 10*time.Second, "how long each -slideshow image is shown")
+	slideshowWritable := flag.Bool("slideshow-writable", false, "accept pixel writes during the -slideshow, which the next image replaces")
+	readOnly := flag.Bool("readonly", false, "reject all pixel writes, only answering reads")
+	testPatternName := flag.String("testpattern", "", "fill the canvas with a test pattern on startup: bars or gradient")
+	mirrorAddr := flag.String("mirror", "", "forward every change of the canvas to the Pixelflut server at this host:port")
+	trackChanges := flag.Bool("track-changes", false, "remember when every pixel last changed for CHANGED (costs 4 bytes per pixel)")
+	trackOwners := flag.Bool("track-owners", false, "remember the last writer of every pixel for WHO (costs 12 bytes per pixel)")
+	grayMode := flag.String("gray-mode", "direct", "how grayscale colors are read: direct (ww means R=G=B=ww) or gamma (ww is linear luminance)")
+	readFormatName := flag.String("read-format", "rgb", "color form of PX read replies: rgb, gray (luma as ww) or auto (ww for gray pixels, rrggbb otherwise)")
+	linearBlend := flag.Bool("linear-blend", false, "blend translucent pixels in linear light instead of sRGB")
+	rotate := flag.Int("rotate", 0, "rotate the display clockwise by 0, 90, 180 or 270 degrees, e.g. for portrait screens")
+	integerScaleMode := flag.Bool("integer-scale", false, "scale the display by whole factors only, letterboxing the rest")
+	adaptiveThreshold := flag.Float64("adaptive-threshold", 0, "pixels per second above which the display refreshes less often (0 disables)")
+	adaptiveFPS := flag.Float64("adaptive-fps", 10, "display refresh rate while above the -adaptive-threshold")
+	bg := flag.String("bg", "", "show this COLOR or a checkerboard beneath transparent pixels on the display")
+	bgImage := flag.String("bg-image", "", "show this image beneath transparent pixels on the display, stretched to the canvas")
+	bloomMode := flag.Bool("bloom", false, "make bright pixels glow on the display")
+	bloomIntensity := flag.Float64("bloom-intensity", 0.8, "strength of the -bloom glow")
+	lang := flag.String("lang", "en", "language of the HELP page: en or de")
+	banner := flag.Bool("banner", false, "greet new connections with a version and size banner")
+	minActivity := flag.Int("min-activity", 0, "close connections that send fewer than this many commands per minute (0 disables)")
+	firstLineTimeout := flag.Duration("first-line-timeout", 0, "close connections that don't send a complete command within this time (0 disables)")
+	slowThreshold := flag.Duration("slow-threshold", 0, "log commands that take longer than this to handle, and replies that take longer to send (0 disables)")
+	connStats := flag.Bool("stats", false, "log a summary of the commands of every connection when it closes")
+	led := flag.String("led", "", "show the canvas on a -width x -height matrix of APA102 LEDs on this spidev device, e.g. /dev/spidev0.0, instead of opening a window (needs -tags ledmatrix)")
+	ledSerpentine := flag.Bool("led-serpentine", false, "the -led chain runs every other row right to left")
+	mono := flag.Int("mono", 0, "show only black and white on the -fbdev or -led output, white from this luma (1-255) on; 0 keeps the colors")
+	fbdev := flag.String("fbdev", "", "show the canvas on this Linux framebuffer device, e.g. /dev/fb0, instead of opening a window")
+	maxRuntime := flag.Duration("max-runtime", 0, "save a snapshot and shut down after running this long (0 runs until stopped)")
+	fifo := flag.String("fifo", "", "write the canvas as raw RGBA frames to this file or named pipe, e.g. for ffmpeg")
+	fifoFPS := flag.Int("fifo-fps", 30, "frames per second written to the -fifo")
+	allow := flag.String("allow", "", "comma separated CIDRs or addresses of the only clients allowed to connect")
+	deny := flag.String("deny", "", "comma separated CIDRs or addresses of clients that may not connect")
+	adminToken := flag.String("admin-token", "", "token required by AUTH to unlock admin commands (empty disables them)")
 	flag.Parse()
 
+	if *listMonitors {
+		for i, m := range ebiten.AppendMonitors(nil) {
+			fmt.Printf("%d: %s\n", i, m.Name())
+		}
+		return
+	}
+
 	log.Println("Starting server on port", *port)
 	log.Println("Serving", *width, "x", *height, "window")
 	log.Println("Debug mode:", *debug)
 
-	g := &Game{
-		debug:        *debug,
-		once:         false,
-		windowWidth:  *width,
-		windowHeight: *height,
-		pixelUpdates: make(chan PixelUpdate, 210000),
+	policy, ok := parseBacklogPolicy(*onBacklog)
+	if !ok {
+		log.Fatal("Unknown -on-backlog policy ", *onBacklog)
+	}
+
+	var budget uint64
+	if *maxMemory != "" {
+		var err error
+		if budget, err = parseByteSize(*maxMemory); err != nil {
+			log.Fatal("-max-memory: ", err)
+		}
+	}
+	// estimated before allocating anything, so a budget that's too small
+	// fails fast
+	var memory memoryEstimate
+	pixels := uint64(max(*width, 0)) * uint64(max(*height, 0))
+	memory.add("canvas", pixels*4*3) // the canvas, the window frame and the window display's copy
+	// the render goroutine swaps in a second buffer while it drains a queue
+	memory.add("queue", 2*uint64(max(*queueSize, 1))*pixelUpdateSize)
+	if *trackOwners {
+		memory.add("owners", pixels*12)
+	}
+	if *trackChanges {
+		memory.add("changes", pixels*4)
+	}
+	if *pixelCooldownFrames > 0 {
+		memory.add("flicker guard", pixels*4)
+	}
+	memory.perConnection = connectionMemory(max(*maxLine, 64))
+	memory.log()
+	if err := memory.check(budget); err != nil {
+		log.Fatal(err)
+	}
+
+	g := newGame(*width, *height, max(*queueSize, 1), min(max(*queueShards, 1), 256))
+	g.debug = *debug
+	g.backlogPolicy = policy
+	g.adminToken = *adminToken
+	g.banner = *banner
+	g.strict = *strict
+	g.lockDuration = *lockDuration
+	g.maxLockArea = max(*maxLockArea, 0)
+	g.maxLocksPerIP = max(*maxLocksPerIP, 0)
+	g.slowThreshold = *slowThreshold
+	g.snapshotDir = *snapshotDir
+	g.snapshotOnShutdown = true
+	g.connStats = *connStats || *debug
+	g.maxLine = max(*maxLine, 64)
+	g.streamFPS = max(*streamFPS, 1)
+	g.config = newConfig(flag.CommandLine, *width, *height)
+	g.integerScale = *integerScaleMode
+	g.linearBlend = *linearBlend
+	if g.readFormat, ok = readFormats[*readFormatName]; !ok {
+		log.Fatal("Unknown -read-format ", *readFormatName)
+	}
+	switch *rotate {
+	case 0, 90, 180, 270:
+		g.rotate = *rotate / 90
+	default:
+		log.Fatal("-rotate must be 0, 90, 180 or 270")
+	}
+	g.firstLineTimeout = *firstLineTimeout
+	g.minActivity = int64(*minActivity)
+	g.readOnly = *readOnly
+	g.lang = *lang
+	switch *grayMode {
+	case "direct":
+	case "gamma":
+		g.grayGamma = true
+	default:
+		log.Fatal("Unknown -gray-mode ", *grayMode)
+	}
+	if *adaptiveThreshold > 0 {
+		g.adaptive = &adaptiveRefresh{
+			threshold: *adaptiveThreshold,
+			interval:  time.Duration(float64(time.Second) / max(*adaptiveFPS, 1)),
+		}
+	}
+	switch {
+	case *bgImage != "":
+		background, err := loadBackground(*bgImage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.background = background
+	case *bg == "checkerboard":
+		g.background = &background{}
+	case *bg != "":
+		c, ok := parseColor(*bg)
+		if !ok {
+			log.Fatal("Invalid -bg ", *bg)
+		}
+		g.background = newColorBackground(c)
+	}
+	if *bloomMode {
+		g.bloom = &bloom{intensity: float32(*bloomIntensity)}
+	}
+	if *trackOwners {
+		g.owners = newOwnerTracker(g.canvas.Rect)
+	}
+	if *trackChanges {
+		g.changes = newChangeTracker(g.canvas.Rect)
+	}
+	if *pixelCooldownFrames > 0 {
+		g.flicker = newFlickerGuard(*pixelCooldownFrames, g.canvas.Rect)
+	}
+	if *seed != 0 {
+		setRandomSource(rand.NewSource(*seed))
+	}
+	if *spritesDir != "" {
+		sprites, err := loadSprites(*spritesDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Loaded", len(sprites), "sprites")
+		g.sprites = sprites
+	}
+	g.overlay.Store(*overlay)
+	var err error
+	if g.access.allow, err = parsePrefixes(*allow); err != nil {
+		log.Fatal("-allow: ", err)
+	}
+	if g.access.deny, err = parsePrefixes(*deny); err != nil {
+		log.Fatal("-deny: ", err)
+	}
+	if *cooldown > 0 {
+		g.cooldowns = newCooldowns(*cooldown, *rateLimitEntries)
+	}
+	if *rateLimit > 0 {
+		g.limiters = newRateLimiters(*rateLimit, *rateLimitEntries)
+	}
+
+	if *testPatternName != "" {
+		pattern, ok := testPatterns[*testPatternName]
+		if !ok {
+			log.Fatal("Unknown -testpattern ", *testPatternName)
+		}
+		// drawn right away, before any client can connect and before the
+		// render loop starts
+		g.fillTestPattern(pattern)
+		g.applyPending()
+	}
+
+	var slides []*image.RGBA
+	if *slideshow != "" {
+		var err error
+		slides, err = loadSlideshow(*slideshow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// set before any listener starts, connections read it unlocked
+		g.readOnly = g.readOnly || !*slideshowWritable
 	}
 
 	// start server, listen on tcp port
-	go func() {
-		err := g.startServer(*port)
+	if *port != 0 {
+		go func() {
+			err := g.startServer("tcp", fmt.Sprintf(":%d", *port))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *portRange != "" {
+		first, last, err := parsePortRange(*portRange)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Also listening on ports", first, "to", last)
+		for p := first; p <= last; p++ {
+			go func(p int) {
+				err := g.startServer("tcp", fmt.Sprintf(":%d", p))
+				if err != nil {
+					log.Fatal(err)
+				}
+			}(p)
+		}
+	}
+	if *unixPath != "" {
+		log.Println("Listening on unix socket", *unixPath)
+		go func() {
+			err := g.startServer("unix", *unixPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	defer g.shutdown()
+
+	if *httpAddr != "" {
+		log.Println("Serving HTTP status on", *httpAddr)
+		go func() {
+			err := g.startHTTPServer(*httpAddr, max(*eventsInterval, 250*time.Millisecond))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if slides != nil {
+		log.Println("Showing", len(slides), "slides from", *slideshow)
+		go g.runSlideshow(slides, max(*slideshowInterval, time.Second/60))
+	}
+	if *animation != "" {
+		blocks, err := loadAnimation(*animation)
 		if err != nil {
 			log.Fatal(err)
 		}
+		go g.runAnimation(blocks, *animationLoop)
+	}
+	if *mirrorAddr != "" {
+		g.mirror = newMirror(*mirrorAddr)
+		go g.mirror.run(g)
+	}
+	if *autoSnapshotInterval > 0 {
+		go g.autoSnapshots(*autoSnapshotInterval, max(*autoSnapshotKeep, 1))
+	}
+	if *fifo != "" {
+		go g.runFIFO(*fifo, max(*fifoFPS, 1))
+	}
+	if *coverageInterval > 0 {
+		go g.logCoverage(*coverageInterval)
+	}
+
+	if *maxRuntime > 0 {
+		go g.shutdownAfter(*maxRuntime)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Println("Received", <-signals)
+		g.shutdown()
 	}()
 
-	ebiten.SetWindowSize(*width, *height)
+	if *testRender {
+		log.Println("Rendering without a window")
+		g.runHeadless()
+		return
+	}
+	if *fbdev != "" {
+		fb, err := openFramebuffer(*fbdev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fb.close()
+		log.Println("Rendering to", *fbdev, "at", fb.width, "x", fb.height)
+		g.display = withMono(fb, *mono)
+		// cover whatever the device showed before
+		g.markDirty(g.canvas.Rect)
+		g.runHeadless()
+		return
+	}
+	if *led != "" {
+		matrix, err := openLEDMatrix(*led, *width, *height, *ledSerpentine)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer matrix.close()
+		log.Println("Rendering to the LED matrix on", *led)
+		g.display = withMono(matrix, *mono)
+		g.markDirty(g.canvas.Rect)
+		g.runHeadless()
+		return
+	}
+
+	g.runWindow(*monitor, *width, *height)
+}
+
+// runWindow shows the canvas in a window of the given canvas size on the
+// given monitor until the window is closed. Without a display to open it on,
+// or if opening it fails, the canvas is rendered without a window instead,
+// as the server doesn't depend on it.
+func (g *Game) runWindow(monitor, width, height int) {
+	if !hasDisplay() {
+		// checked before ebiten is asked for anything, as it may abort the
+		// program rather than return an error without a display
+		log.Println("Warning: no display found, rendering without a window")
+		g.runHeadless()
+		return
+	}
+
+	if monitors := ebiten.AppendMonitors(nil); monitor >= 0 && monitor < len(monitors) {
+		ebiten.SetMonitor(monitors[monitor])
+	} else {
+		log.Println("Monitor", monitor, "not found, using the primary monitor")
+	}
+	ebiten.SetWindowSize(g.displaySize(width, height))
+	if g.integerScale {
+		ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+	}
 	ebiten.SetWindowTitle("Hello, World!")
 	if err := ebiten.RunGame(g); err != nil {
-		log.Fatal(err)
+		// RunGame only fails before the game ends if the window or its
+		// graphics can't be set up
+		log.Println("Warning: can't open a window, rendering without one:", err)
+		g.runHeadless()
+	}
+}
+
+// hasDisplay reports whether there may be a display to open a window on. On
+// systems using X11 or Wayland, that takes one of their environment
+// variables; elsewhere there always is one. Versions of ebiten that connect
+// to the display while the program starts, like 2.6, still fail before this
+// is checked.
+func hasDisplay() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios", "android", "js":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// parsePortRange parses a -ports range like 1338-1341.
+func parsePortRange(s string) (first, last int, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		to = from
+	}
+	first, err = strconv.Atoi(from)
+	if err == nil {
+		last, err = strconv.Atoi(to)
 	}
+	if err != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return first, last, nil
 }
 
-func (g *Game) startServer(port int) interface{} {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+func (g *Game) startServer(network, address string) error {
+	if network == "unix" {
+		// remove a socket left behind by a previous run
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer listener.Close()
 
+	g.listenersMu.Lock()
+	g.listeners = append(g.listeners, listener)
+	g.listenersMu.Unlock()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			if g.debug {
 				log.Println("Error accepting connection:", err)
 			}
 			continue
 		}
 
+		if !g.access.permits(conn.RemoteAddr()) {
+			if g.debug {
+				log.Println("Rejected connection from", conn.RemoteAddr())
+			}
+			go reject(conn)
+			continue
+		}
 		go g.handleConnection(conn)
 	}
+}
 
-	return nil
+// shutdown saves a snapshot if snapshotOnShutdown is set, stops accepting
+// connections, stops the render loop and releases every connection handler.
+// It is safe to call more than once.
+func (g *Game) shutdown() {
+	g.shutdownOnce.Do(func() {
+		log.Println("Shutting down")
+		if g.snapshotOnShutdown {
+			if err := g.saveSnapshot(); err != nil {
+				log.Println("Error saving snapshot:", err)
+			}
+		}
+		close(g.done)
+		for _, queue := range g.pixelUpdates {
+			// release writers waiting for room
+			queue.close()
+		}
+		g.closeListeners()
+	})
 }
 
-func (g *Game) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// shutdownAfter shuts down once d has passed, for -max-runtime.
+func (g *Game) shutdownAfter(d time.Duration) {
+	log.Println("Shutting down at", g.clock.Now().Add(d).Format(time.RFC3339))
+	select {
+	case <-g.done:
+	case <-g.clock.After(d):
+		log.Println("Reached -max-runtime")
+		g.shutdown()
+	}
+}
 
-	// read data
-	buf := make([]byte, 10240)
+// closeListeners stops accepting connections. Closing a Unix socket listener
+// also removes its socket file.
+func (g *Game) closeListeners() {
+	g.listenersMu.Lock()
+	defer g.listenersMu.Unlock()
+
+	for _, listener := range g.listeners {
+		listener.Close()
+	}
+	g.listeners = nil
+}
 
+// activityWindow is the period -min-activity counts commands over.
+const activityWindow = time.Minute
+
+// watchActivity closes the connection once it sends fewer than minActivity
+// commands within an activityWindow. Unlike a read timeout, this also catches
+// clients that keep a connection open by trickling a command now and then.
+func (g *Game) watchActivity(state *connState) {
 	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			if err != io.EOF {
-				if g.debug {
-					log.Println("Error reading:", err)
-				}
-			}
-			if g.debug {
-				log.Println("Connection closed")
-			}
+		select {
+		case <-state.done:
 			return
+		case <-g.clock.After(activityWindow):
 		}
 
-		lastNewlineIndex := -1
-		for i := 0; i < n; i++ {
-			if buf[i] == '\n' {
-				g.handleLine(string(buf[lastNewlineIndex+1:i]), conn)
-				lastNewlineIndex = i
+		if n := state.activity.Swap(0); n < g.minActivity {
+			if g.debug {
+				log.Printf("Closing %s after %d commands in %v", state.conn.RemoteAddr(), n, activityWindow)
 			}
+			state.conn.Close()
+			return
 		}
-		copy(buf, buf[lastNewlineIndex+1:])
 	}
 }
 
-func (g *Game) handleLine(line string, conn net.Conn) {
-	if g.debug {
-		//log.Println("Received:", line)
-		defer log.Println("Handled line")
+func (g *Game) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	g.stats.connections.Add(1)
+	defer g.stats.connections.Add(-1)
+
+	state := &connState{
+		conn: conn,
+		out:  make(chan reply, outQueueSize),
+		done: make(chan struct{}),
+	}
+	state.ip, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	g.conns.add(state.ip)
+	defer g.conns.remove(state.ip)
+	if g.owners != nil && state.ip != "" {
+		state.ownerID = g.owners.id(state.ip)
 	}
 
-	if strings.HasPrefix(line, "SIZE") {
-		// send window size
-		_, err := conn.Write([]byte(fmt.Sprintf("SIZE %d %d\n", g.windowWidth, g.windowHeight)))
-		if err != nil {
-			return
-		}
-	} else if strings.HasPrefix(line, "PX") {
-		fields := strings.Split(line, " ")
-		if g.debug {
-			//log.Println(fields)
+	writerDone := make(chan struct{})
+	go state.writeLoop(writerDone, g.slowThreshold)
+	go func() {
+		// unblock the reader on shutdown
+		select {
+		case <-g.done:
+			conn.Close()
+		case <-state.done:
 		}
+	}()
+	if g.minActivity > 0 {
+		go g.watchActivity(state)
+	}
+	connected := time.Now()
+	defer func() {
+		if g.connStats {
+			log.Printf("Connection from %s closed after %v: %d commands, %d pixel writes, %d reads, %d errors",
+				conn.RemoteAddr(), time.Since(connected).Round(time.Millisecond), state.commands, state.pixelWrites, state.reads, state.errors)
+		}
+	}()
+	defer func() {
+		close(state.done)
+		state.workers.Wait()
+		g.locks.unlock(state)
+
+		// give the client a moment to receive outstanding replies
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		close(state.out)
+		<-writerDone
+		if state.shutdownRequested {
+			g.shutdown()
+		}
+	}()
+
+	if g.banner {
+		width, height := g.size()
+		state.write([]byte(fmt.Sprintf("PIXELFLUT %s %dx%d\n", version, width, height)))
+	}
+
+	// read data
+	scanner := state.newScanner(g.maxLine)
+	defer state.releaseScanner()
+	if g.firstLineTimeout > 0 {
+		// unlike an idle timeout, dribbling bytes doesn't extend this
+		conn.SetReadDeadline(time.Now().Add(g.firstLineTimeout))
+	}
+	for !state.shutdownRequested && scanner.Scan() {
+		if state.commands == 0 && g.firstLineTimeout > 0 {
+			conn.SetReadDeadline(time.Time{})
+		}
+		state.commands++
+		if g.minActivity > 0 {
+			state.activity.Add(1)
+		}
+		if state.binaryToken {
+			state.binaryToken = false
+			g.handleBinaryPixel(scanner.Bytes(), state)
+			continue
+		}
+		line := string(scanner.Bytes())
+		if g.slowThreshold <= 0 {
+			g.handleLine(line, state)
+			continue
+		}
+		start := time.Now()
+		g.handleLine(line, state)
+		if elapsed := time.Since(start); elapsed > g.slowThreshold {
+			log.Printf("Slow command from %s took %v: %.64q", conn.RemoteAddr(), elapsed, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if g.debug {
+			log.Println("Error reading:", err)
+		}
+	}
+	if g.debug {
+		log.Println("Connection closed")
+	}
+}
+
+// maxScale bounds the factors accepted by SCALE.
+const maxScale = 16
+
+// canvasPoint maps the pixel coordinates (x, y) sent by the client to the
+// canvas, scaling them by SCALE and then moving them by OFFSET. Regions and
+// distances are only moved.
+func (s *connState) canvasPoint(x, y int) (int, int) {
+	if s.scaled {
+		// clamped, so the sum with the offset still fits into PixelUpdate
+		x = int(min(max(math.Floor(float64(x)*s.scaleX), -maxCoordinate), maxCoordinate))
+		y = int(min(max(math.Floor(float64(y)*s.scaleY), -maxCoordinate), maxCoordinate))
+	}
+	return x + s.offsetX, y + s.offsetY
+}
+
+// maxCoordinate bounds the magnitude of coordinates and offsets accepted from
+// clients, so that sums of a coordinate and an offset always fit into the
+// int32 fields of PixelUpdate.
+const maxCoordinate = 1 << 24
+
+// parseCoordinate parses a decimal coordinate, rejecting values outside
+// ±maxCoordinate.
+func parseCoordinate(s string) (int, error) {
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if v < -maxCoordinate || v > maxCoordinate {
+		return 0, fmt.Errorf("coordinate %d out of range", v)
+	}
+	return int(v), nil
+}
+
+// parseRect parses the x, y, w and h arguments of a region command.
+func parseRect(args []string) (image.Rectangle, error) {
+	var v [4]int
+	for i, arg := range args {
+		n, err := parseCoordinate(arg)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		v[i] = n
+	}
+	if v[2] < 0 || v[3] < 0 {
+		return image.Rectangle{}, errors.New("negative region size")
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// writePixel queues c to be drawn at the canvas coordinates (x, y) on behalf
+// of the connection.
+func (g *Game) writePixel(state *connState, x, y int, c color.RGBA) {
+	update, ok := g.admitPixel(state, x, y, c)
+	if !ok {
+		return
+	}
+	if state.holding {
+		if len(state.held) >= maxHeldPixels {
+			g.stats.dropped.Add(1)
+			return
+		}
+		state.held = append(state.held, update)
+		return
+	}
+	if state.taskFence != nil {
+		select {
+		case <-state.taskFence:
+		case <-g.done:
+		}
+		state.taskFence = nil
+	}
+	g.enqueue(update)
+}
+
+// rejectReadOnly reports whether writes are rejected because the canvas is
+// read-only, replying with an error in strict mode.
+func (g *Game) rejectReadOnly(state *connState) bool {
+	if !g.readOnly {
+		return false
+	}
+	g.stats.dropped.Add(1)
+	if g.strict {
+		state.write([]byte("ERROR canvas is read-only\n"))
+	}
+	return true
+}
+
+// admitPixel checks whether the connection may write c at the canvas
+// coordinates (x, y) right now and returns the update to queue if so.
+func (g *Game) admitPixel(state *connState, x, y int, c color.RGBA) (PixelUpdate, bool) {
+	// clamped, so a long run of PXR can't overflow the position
+	state.lastX = min(max(x, -maxCoordinate), maxCoordinate)
+	state.lastY = min(max(y, -maxCoordinate), maxCoordinate)
+	state.pixelWrites++
+
+	// the bounds are checked here, after OFFSET was applied, so negative
+	// coordinates that land on the canvas are fine
+	if g.rejectReadOnly(state) {
+		return PixelUpdate{}, false
+	}
+	if !g.inCanvas(x, y) {
+		g.stats.dropped.Add(1)
+		if g.strict {
+			state.write([]byte("ERROR out of bounds\n"))
+		}
+		return PixelUpdate{}, false
+	}
+	if g.protected.contains(image.Point{x, y}) {
+		g.stats.dropped.Add(1)
+		if g.strict {
+			state.write([]byte("ERROR region is protected\n"))
+		}
+		return PixelUpdate{}, false
+	}
+	if !g.locks.allows(state, image.Point{x, y}) {
+		g.stats.dropped.Add(1)
+		if g.strict {
+			state.write([]byte("ERROR region is locked\n"))
+		}
+		return PixelUpdate{}, false
+	}
+	if g.cooldowns != nil && !state.unlimited {
+		if ok, remaining := g.cooldowns.allow(state.ip, time.Now()); !ok {
+			g.stats.dropped.Add(1)
+			if g.strict {
+				state.write([]byte(fmt.Sprintf("ERROR cooldown %d\n", int(math.Ceil(remaining.Seconds())))))
+			}
+			return PixelUpdate{}, false
+		}
+	}
+	if g.limiters != nil && !state.unlimited && !g.limiters.allow(state.ip, time.Now()) {
+		g.stats.dropped.Add(1)
+		return PixelUpdate{}, false
+	}
+
+	return PixelUpdate{
+		x:     int32(x),
+		y:     int32(y),
+		color: c,
+		owner: state.ownerID,
+		blend: state.blend,
+	}, true
+}
+
+// handleBinaryPixel handles a binary PB frame, see pbFrameSize.
+func (g *Game) handleBinaryPixel(frame []byte, state *connState) {
+	x := int(binary.LittleEndian.Uint16(frame[2:4]))
+	y := int(binary.LittleEndian.Uint16(frame[4:6]))
+	c := color.NRGBA{frame[6], frame[7], frame[8], frame[9]}
+
+	cx, cy := state.canvasPoint(x, y)
+	g.writePixel(state, cx, cy, color.RGBAModel.Convert(c).(color.RGBA))
+}
+
+// splitFields splits a command line on runs of spaces and tabs, so
+// hand-written commands with irregular spacing or a trailing CR still parse.
+// Other whitespace, like a non-breaking space, is part of a field.
+func splitFields(line string) []string {
+	return strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r'
+	})
+}
+
+// hasControlBytes reports whether line contains an ASCII control character
+// other than the tab and CR that hand-written commands may contain. No
+// command takes them, and they could end up in logs or replies.
+func hasControlBytes(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; (c < ' ' && c != '\t' && c != '\r') || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Game) handleLine(line string, state *connState) {
+	if g.debug {
+		//log.Println("Received:", line)
+		defer log.Println("Handled line")
+	}
+
+	if g.strict && hasControlBytes(line) {
+		state.write([]byte("ERROR control character in command\n"))
+		return
+	}
+
+	fields := splitFields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	switch fields[0] {
+	case "SIZE":
+		// send window size
+		width, height := g.size()
+		state.write([]byte(fmt.Sprintf("SIZE %d %d\n", width, height)))
+	case "CLIENT":
+		if len(fields) < 2 {
+			return
+		}
+		state.client = fields[1]
+		accepted := []string{"CLIENT", state.client}
+		for _, feature := range fields[2:] {
+			switch feature {
+			case "binary":
+				state.binaryReplies = true
+			default:
+				// unknown features are for other servers
+				continue
+			}
+			accepted = append(accepted, feature)
+		}
+		state.write([]byte(strings.Join(accepted, " ") + "\n"))
+	case "PING":
+		if len(fields) > 2 {
+			return
+		}
+		state.write([]byte(strings.Join(append([]string{"PONG"}, fields[1:]...), " ") + "\n"))
+	case "BOUNDS":
+		// the canvas in this connection's coordinates
+		width, height := g.size()
+		x, y := -state.offsetX, -state.offsetY
+		if state.scaled {
+			x, y = int(math.Ceil(float64(x)/state.scaleX)), int(math.Ceil(float64(y)/state.scaleY))
+			width, height = int(float64(width)/state.scaleX), int(float64(height)/state.scaleY)
+		}
+		state.write([]byte(fmt.Sprintf("BOUNDS %d %d %d %d\n", x, y, width, height)))
+	case "LIMITS":
+		state.write(g.encodeLimits())
+	case "QUEUE":
+		state.write([]byte(fmt.Sprintf("QUEUE %d %d %d\n", g.queuedUpdates(), g.queueCapacity(), g.queueLatency().Milliseconds())))
+	case "CONNS":
+		state.write([]byte(fmt.Sprintf("CONNS %d\n", g.conns.count(state.ip))))
+	case "UPTIME":
+		uptime := time.Since(g.startTime)
+		state.write([]byte(fmt.Sprintf("UPTIME %d %s\n", int64(uptime.Seconds()), g.startTime.UTC().Format(time.RFC3339))))
+	case "PX":
 		if len(fields) == 3 {
-			x, err := strconv.Atoi(fields[1])
+			x, err := parseCoordinate(fields[1])
 			if err != nil {
 				return
 			}
-			y, err := strconv.Atoi(fields[2])
+			y, err := parseCoordinate(fields[2])
 			if err != nil {
 				return
 			}
 
-			if x < 0 || x >= g.windowWidth || y < 0 || y >= g.windowHeight {
+			// get colorAt from canvas
+			g.canvasMu.RLock()
+			at := image.Pt(state.canvasPoint(x, y))
+			if !at.In(g.canvas.Rect) {
+				g.canvasMu.RUnlock()
 				return
 			}
-
-			// get colorAt from screen
-			colorAt := g.lastScreen.At(x, y).(color.RGBA)
-			// convert to hex string
-			colorString := fmt.Sprintf("%02x%02x%02x", colorAt.R, colorAt.G, colorAt.B)
-
-			_, err = conn.Write([]byte(fmt.Sprintf("PX %d %d %s\n", x, y, colorString)))
+			colorAt := g.canvas.RGBAAt(at.X, at.Y)
+			g.canvasMu.RUnlock()
+			state.reads++
+			if state.binaryReplies && x >= 0 && x <= math.MaxUint16 && y >= 0 && y <= math.MaxUint16 {
+				state.write(encodeBinaryPixel(x, y, colorAt))
+				return
+			}
+			state.write([]byte(fmt.Sprintf("PX %d %d %s\n", x, y, g.formatColor(colorAt))))
 		} else if len(fields) == 4 {
-			x, err := strconv.Atoi(fields[1])
+			x, err := parseCoordinate(fields[1])
 			if err != nil {
 				return
 			}
-			y, err := strconv.Atoi(fields[2])
+			y, err := parseCoordinate(fields[2])
 			if err != nil {
 				return
 			}
-			colorString := fields[3]
+			c, ok := g.parseColor(fields[3])
+			if !ok {
+				return
+			}
 
-			if len(colorString) == 6 {
-				r, err := strconv.ParseInt(colorString[0:2], 16, 0)
-				if err != nil {
-					return
-				}
-				gr, err := strconv.ParseInt(colorString[2:4], 16, 0)
-				if err != nil {
-					return
-				}
-				b, err := strconv.ParseInt(colorString[4:6], 16, 0)
-				if err != nil {
-					return
-				}
+			cx, cy := state.canvasPoint(x, y)
+			g.writePixel(state, cx, cy, c)
+		}
+	case "PXAT":
+		if len(fields) != 5 {
+			return
+		}
+		frame, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return
+		}
+		x, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[3])
+		if err != nil {
+			return
+		}
+		c, ok := g.parseColor(fields[4])
+		if !ok {
+			return
+		}
 
-				g.pixelUpdates <- PixelUpdate{
-					x:     int32(x),
-					y:     int32(y),
-					color: color.RGBA{uint8(r), uint8(gr), uint8(b), 255},
-				}
-			} else if len(colorString) == 8 {
-				r, err := strconv.ParseInt(colorString[0:2], 16, 0)
-				if err != nil {
-					return
-				}
-				gr, err := strconv.ParseInt(colorString[2:4], 16, 0)
-				if err != nil {
-					return
-				}
-				b, err := strconv.ParseInt(colorString[4:6], 16, 0)
-				if err != nil {
-					return
-				}
-				a, err := strconv.ParseInt(colorString[6:8], 16, 0)
-				if err != nil {
-					return
-				}
+		cx, cy := state.canvasPoint(x, y)
+		update, ok := g.admitPixel(state, cx, cy, c)
+		if !ok {
+			return
+		}
+		if err := g.schedule(frame, update); err != nil {
+			g.stats.dropped.Add(1)
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+		}
+	case "PXFADE":
+		if len(fields) != 5 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		c, ok := g.parseColor(fields[3])
+		if !ok {
+			return
+		}
+		frames, err := strconv.Atoi(fields[4])
+		if err != nil || frames < 1 || frames > maxFadeFrames {
+			return
+		}
+
+		cx, cy := state.canvasPoint(x, y)
+		update, ok := g.admitPixel(state, cx, cy, c)
+		if !ok {
+			return
+		}
+		g.fadePixel(state, update, frames)
+	case "FRAME":
+		state.write([]byte(fmt.Sprintf("FRAME %d\n", g.frameNumber.Load())))
+	case "PXR":
+		if len(fields) != 4 {
+			return
+		}
+		dx, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		dy, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		c, ok := g.parseColor(fields[3])
+		if !ok {
+			return
+		}
 
-				g.pixelUpdates <- PixelUpdate{
-					x:     int32(x),
-					y:     int32(y),
-					color: color.RGBA{uint8(r), uint8(gr), uint8(b), uint8(a)},
+		// the last position already has the offset applied
+		g.writePixel(state, state.lastX+dx, state.lastY+dy, c)
+	case "PXN":
+		if len(fields) != 4 {
+			return
+		}
+		fx, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(fx) {
+			return
+		}
+		fy, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(fy) {
+			return
+		}
+		c, ok := g.parseColor(fields[3])
+		if !ok {
+			return
+		}
+
+		// normalized coordinates address the whole canvas, ignoring OFFSET
+		width, height := g.size()
+		x := min(int(min(max(fx, 0), 1)*float64(width)), width-1)
+		y := min(int(min(max(fy, 0), 1)*float64(height)), height-1)
+		g.writePixel(state, x, y, c)
+	case "STAMP":
+		if len(fields) != 4 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		sprite, ok := g.sprites[fields[3]]
+		if !ok {
+			state.write([]byte("ERROR unknown sprite\n"))
+			return
+		}
+
+		if g.rejectReadOnly(state) {
+			return
+		}
+		cx, cy := state.canvasPoint(x, y)
+		g.stamp(state, sprite, cx, cy)
+	case "PXCAS":
+		if len(fields) != 5 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		expected, ok := g.parseColor(fields[3])
+		if !ok {
+			return
+		}
+		c, ok := g.parseColor(fields[4])
+		if !ok {
+			return
+		}
+
+		// admitted like a PX, but never held
+		cx, cy := state.canvasPoint(x, y)
+		update, ok := g.admitPixel(state, cx, cy, c)
+		result := "FAIL"
+		if ok && g.compareAndSet(update, expected) {
+			result = "OK"
+		}
+		state.write([]byte(fmt.Sprintf("PXCAS %d %d %s\n", x, y, result)))
+	case "FLOODFILL":
+		if len(fields) != 4 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		c, ok := g.parseColor(fields[3])
+		if !ok {
+			return
+		}
+
+		if g.rejectReadOnly(state) {
+			return
+		}
+		cx, cy := state.canvasPoint(x, y)
+		g.floodFill(state, cx, cy, c)
+	case "BLEND":
+		if len(fields) != 2 {
+			return
+		}
+		mode, ok := blendModes[fields[1]]
+		if !ok {
+			state.write([]byte("ERROR unknown blend mode\n"))
+			return
+		}
+		state.blend = mode
+	case "CIRCLE", "DISC", "BRUSH":
+		if len(fields) != 5 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		r, err := strconv.Atoi(fields[3])
+		if err != nil || r < 0 || r > maxCanvasSize {
+			return
+		}
+		c, ok := g.parseColor(fields[4])
+		if !ok {
+			return
+		}
+
+		if g.rejectReadOnly(state) {
+			return
+		}
+		cx, cy := state.canvasPoint(x, y)
+		switch fields[0] {
+		case "CIRCLE":
+			g.circle(state, cx, cy, r, c)
+		case "DISC":
+			g.disc(state, cx, cy, r, c)
+		case "BRUSH":
+			g.brush(state, cx, cy, r, c)
+		}
+	case "HOLD":
+		state.holding = true
+	case "FLUSH":
+		held, heldFades := state.held, state.heldFades
+		state.holding = false
+		state.held, state.heldFades = nil, nil
+
+		if len(held) > 0 || len(heldFades) > 0 {
+			// apply all held writes and start the fades within a single
+			// frame
+			g.queueTask(func() {
+				for _, update := range held {
+					g.applyUpdate(update)
 				}
-			} else if len(colorString) == 2 {
-				gray, err := strconv.ParseInt(colorString, 16, 0)
-				if err != nil {
-					return
+				g.stats.pixels.Add(uint64(len(held)))
+				for _, fade := range heldFades {
+					g.startFade(fade)
 				}
+			})
+			state.taskFence = g.fence()
+		}
+	case "OFFSET":
+		if len(fields) != 3 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
 
-				g.pixelUpdates <- PixelUpdate{
-					x:     int32(x),
-					y:     int32(y),
-					color: color.RGBA{uint8(gray), uint8(gray), uint8(gray), 255},
-				}
+		state.offsetX, state.offsetY = x, y
+	case "SCALE":
+		if len(fields) != 3 {
+			return
+		}
+		fx, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || !(fx > 0 && fx <= maxScale) {
+			return
+		}
+		fy, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || !(fy > 0 && fy <= maxScale) {
+			return
+		}
+
+		state.scaleX, state.scaleY = fx, fy
+		state.scaled = fx != 1 || fy != 1
+	case "AVG":
+		if len(fields) != 4 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		radius, err := parseCoordinate(fields[3])
+		if err != nil || radius < 0 {
+			return
+		}
+		radius = min(radius, maxCanvasSize)
+
+		cx, cy := state.canvasPoint(x, y)
+		avg, ok := g.averageColor(image.Rect(cx-radius, cy-radius, cx+radius+1, cy+radius+1))
+		if !ok {
+			return
+		}
+		state.write([]byte(fmt.Sprintf("AVG %d %d %d %02x%02x%02x\n", x, y, radius, avg.R, avg.G, avg.B)))
+	case "DOMINANT":
+		c, share := g.dominantColor()
+		state.write([]byte(fmt.Sprintf("DOMINANT %02x%02x%02x %.4f\n", c.R, c.G, c.B, share)))
+	case "COLORS":
+		state.write([]byte("COLORS " + strings.Join(g.colorFormats(), " ") + "\n"))
+	case "COVERAGE":
+		state.write([]byte(fmt.Sprintf("COVERAGE %.4f\n", g.coverage())))
+	case "LOCK":
+		if len(fields) != 5 {
+			return
+		}
+		r, err := parseRect(fields[1:5])
+		if err != nil {
+			return
+		}
+
+		// only the part on the canvas counts towards the area
+		width, height := g.size()
+		r = r.Add(image.Point{state.offsetX, state.offsetY}).Intersect(image.Rect(0, 0, width, height))
+		maxPerIP := 0
+		if !state.authed {
+			if g.maxLockArea > 0 && r.Dx()*r.Dy() > g.maxLockArea {
+				state.write([]byte(fmt.Sprintf("ERROR a lock may cover at most %d pixels\n", g.maxLockArea)))
+				return
 			}
+			maxPerIP = g.maxLocksPerIP
+		}
+		if err := g.locks.lock(r, state, time.Now().Add(g.lockDuration), maxPerIP); err != nil {
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+			return
+		}
+		state.write([]byte(fmt.Sprintf("LOCK OK %d\n", int(g.lockDuration.Seconds()))))
+	case "UNLOCK":
+		g.locks.unlock(state)
+	case "AUTH":
+		if len(fields) == 2 && g.adminToken != "" && fields[1] == g.adminToken {
+			state.authed = true
+			state.write([]byte("AUTH OK\n"))
+		} else {
+			state.write([]byte("ERROR unauthorized\n"))
 		}
-	} else if strings.HasPrefix(line, "HELP") {
-		_, err := conn.Write([]byte("Welcome to Pixelflut!\n\nCommands:\n    HELP                -> get this information page\n    SIZE                -> get the size of the canvas\n    PX <x> <y>          -> get the color of pixel (x, y)\n    PX <x> <y> <COLOR>  -> set the color of pixel (x, y)\n    OFFSET <x> <y>      -> sets an pixel offset for all following commands\n\n    COLOR:\n        Grayscale: ww          (\"00\"       black .. \"ff\"       white)\n        RGB:       rrggbb      (\"000000\"   black .. \"ffffff\"   white)\n        RGBA:      rrggbbaa    (rgb with alpha)\n\nExample:\n    \"PX 420 69 ff\\n\"       -> set the color of pixel at (420, 69) to white\n    \"PX 420 69 00ffff\\n\"   -> set the color of pixel at (420, 69) to cyan\n    \"PX 420 69 ffff007f\\n\" -> blend the color of pixel at (420, 69) with yellow (alpha 127)\n"))
+	case "PAUSE", "RESUME":
+		if !state.requireAuth() {
+			return
+		}
+		g.setPaused(fields[0] == "PAUSE")
+	case "PRIORITY":
+		if !state.requireAuth() {
+			return
+		}
+		if len(fields) == 2 && fields[1] == "clear" {
+			g.priority.reset()
+			state.write([]byte("PRIORITY cleared\n"))
+			return
+		}
+		if len(fields) != 5 {
+			return
+		}
+		r, err := parseRect(fields[1:5])
 		if err != nil {
 			return
 		}
+
+		g.priority.add(r.Add(image.Point{state.offsetX, state.offsetY}))
+		state.write([]byte("PRIORITY OK\n"))
+	case "COPY":
+		if !state.requireAuth() {
+			return
+		}
+		if len(fields) != 7 {
+			return
+		}
+		src, err := parseRect(fields[1:5])
+		if err != nil {
+			return
+		}
+		dstX, err := parseCoordinate(fields[5])
+		if err != nil {
+			return
+		}
+		dstY, err := parseCoordinate(fields[6])
+		if err != nil {
+			return
+		}
+
+		offset := image.Point{state.offsetX, state.offsetY}
+		g.copyRegion(src.Add(offset), image.Point{dstX, dstY}.Add(offset))
+		state.taskFence = g.fence()
+	case "PROTECT", "UNPROTECT":
+		if !state.requireAuth() {
+			return
+		}
+		if fields[0] == "UNPROTECT" && len(fields) == 1 {
+			g.protected.reset()
+			state.write([]byte("UNPROTECT all\n"))
+			return
+		}
+		if len(fields) != 5 {
+			return
+		}
+		r, err := parseRect(fields[1:5])
+		if err != nil {
+			return
+		}
+
+		r = r.Add(image.Point{state.offsetX, state.offsetY})
+		if fields[0] == "PROTECT" {
+			g.protected.add(r)
+		} else {
+			g.protected.remove(r)
+		}
+		state.write([]byte(fields[0] + " OK\n"))
+	case "PALETTE":
+		if !state.requireAuth() {
+			return
+		}
+		switch {
+		case len(fields) == 2 && fields[1] == "list":
+			reply := strings.Join(append([]string{"PALETTE"}, g.palette.list()...), " ")
+			state.write([]byte(reply + "\n"))
+		case len(fields) > 2 && fields[1] == "set":
+			if err := g.palette.set(fields[2:]); err != nil {
+				state.write([]byte("ERROR " + err.Error() + "\n"))
+				return
+			}
+			state.write([]byte("PALETTE OK\n"))
+		}
+	case "CHANSTATS":
+		if !state.requireAuth() {
+			return
+		}
+		state.write(g.encodeChanStats())
+	case "CONFIG":
+		if !state.requireAuth() {
+			return
+		}
+		state.write(g.encodeConfig())
+	case "SHUTDOWN":
+		if !state.requireAuth() {
+			return
+		}
+		log.Println("Shutdown requested by", state.conn.RemoteAddr())
+		state.write([]byte("SHUTDOWN\n"))
+		state.shutdownRequested = true
+	case "RESIZE":
+		if !state.requireAuth() {
+			return
+		}
+		if len(fields) != 3 {
+			return
+		}
+		width, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return
+		}
+		height, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		if width < 1 || width > maxCanvasSize || height < 1 || height > maxCanvasSize {
+			state.write([]byte(fmt.Sprintf("ERROR size must be between 1 and %d\n", maxCanvasSize)))
+			return
+		}
+
+		g.resize(width, height)
+		state.taskFence = g.fence()
+		ebiten.SetWindowSize(g.displaySize(width, height))
+	case "CHANGED":
+		if len(fields) != 2 {
+			return
+		}
+		frame, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return
+		}
+		if g.changes == nil {
+			state.write([]byte("ERROR change tracking is disabled\n"))
+			return
+		}
+		state.write(g.encodeChanged(uint32(frame), state))
+	case "STATE":
+		state.write(g.encodeState())
+	case "SNAPSHOT":
+		if len(fields) != 2 || fields[1] != "base64" {
+			state.write([]byte("ERROR only SNAPSHOT base64 is supported\n"))
+			return
+		}
+		reply, err := g.snapshotBase64()
+		if err != nil {
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+			return
+		}
+		state.write(reply)
+	case "COMPRESS":
+		if len(fields) != 2 || fields[1] != "on" {
+			// a deflate stream can't be switched back to plain text
+			state.write([]byte("ERROR only COMPRESS on is supported\n"))
+			return
+		}
+		if !state.compressed {
+			state.compressed = true
+			state.write([]byte("COMPRESS on\n"))
+			state.startCompression()
+		}
+	case "STREAM":
+		if len(fields) != 2 || fields[1] != "on" {
+			return
+		}
+		if !state.streaming {
+			state.streaming = true
+			state.write([]byte("STREAM on\n"))
+			state.workers.Add(1)
+			go g.streamFrames(state)
+		}
+	case "FILTER":
+		if !state.requireAuth() {
+			return
+		}
+		if len(fields) != 6 {
+			return
+		}
+		r, err := parseRect(fields[1:5])
+		if err != nil {
+			return
+		}
+		filter, ok := parseFilter(fields[5])
+		if !ok {
+			state.write([]byte("ERROR unknown filter\n"))
+			return
+		}
+
+		g.filterRegion(r.Add(image.Point{state.offsetX, state.offsetY}), filter)
+		state.taskFence = g.fence()
+	case "WHO":
+		if !state.requireAuth() {
+			return
+		}
+		if len(fields) != 3 {
+			return
+		}
+		x, err := parseCoordinate(fields[1])
+		if err != nil {
+			return
+		}
+		y, err := parseCoordinate(fields[2])
+		if err != nil {
+			return
+		}
+		if g.owners == nil {
+			state.write([]byte("ERROR owner tracking is disabled\n"))
+			return
+		}
+
+		g.canvasMu.RLock()
+		owner, at := g.owners.lookup(state.canvasPoint(x, y))
+		g.canvasMu.RUnlock()
+		if owner == 0 {
+			state.write([]byte(fmt.Sprintf("WHO %d %d none\n", x, y)))
+			return
+		}
+		state.write([]byte(fmt.Sprintf("WHO %d %d %s %s\n", x, y, g.owners.addr(owner), at.UTC().Format(time.RFC3339))))
+	case "OWNERMAP":
+		if !state.requireAuth() {
+			return
+		}
+		if g.owners == nil {
+			state.write([]byte("ERROR owner tracking is disabled\n"))
+			return
+		}
+		path, err := g.exportOwnerMap()
+		if err != nil {
+			log.Println("Error exporting owner map:", err)
+			state.write([]byte("ERROR export failed\n"))
+			return
+		}
+		state.write([]byte(fmt.Sprintf("OWNERMAP %s\n", path)))
+	case "EXPORTSVG":
+		if !state.requireAuth() {
+			return
+		}
+		path, err := g.exportSVG()
+		if err != nil {
+			log.Println("Error exporting SVG:", err)
+			state.write([]byte("ERROR export failed\n"))
+			return
+		}
+		state.write([]byte(fmt.Sprintf("EXPORTSVG %s\n", path)))
+	case "PXD":
+		if len(fields) != 2 {
+			return
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			return
+		}
+
+		err = g.handleDeltaPixels(state.payload(n), n, state)
+		if err != nil {
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+		}
+	case "PUTSTATE":
+		if len(fields) != 2 {
+			return
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			return
+		}
+		if !state.requireAuth() {
+			// skip the payload, or it would be read as commands
+			io.Copy(io.Discard, state.payload(n))
+			return
+		}
+
+		err = g.putState(state.payload(n), n)
+		if err != nil {
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+			return
+		}
+		state.taskFence = g.fence()
+	case "REGIONSET":
+		if len(fields) != 5 {
+			return
+		}
+		r, err := parseRect(fields[1:5])
+		if err != nil {
+			return
+		}
+		payload := state.payload(int64(r.Dx()) * int64(r.Dy()) * 4)
+		if g.rejectReadOnly(state) {
+			// skip the payload, or it would be read as commands
+			io.Copy(io.Discard, payload)
+			return
+		}
+
+		err = g.putRegion(payload, r.Add(image.Point{state.offsetX, state.offsetY}), state)
+		if err != nil {
+			state.write([]byte(fmt.Sprintf("ERROR %v\n", err)))
+			return
+		}
+		state.taskFence = g.fence()
+	case "HELP":
+		if len(fields) == 2 && fields[1] == "json" {
+			state.write(g.helpJSON())
+			return
+		}
+		lang := g.lang
+		if len(fields) == 2 {
+			lang = fields[1]
+		}
+		state.write([]byte(helpText(lang)))
+	default:
+		g.runCustomCommand(fields, state)
 	}
 }
@@ -0,0 +1,875 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGame returns a game with a canvas of the given size that is shut
+// down when the test ends.
+func newTestGame(t testing.TB, width, height int) *Game {
+	t.Helper()
+	g := newGame(width, height, 1024, 1)
+	g.snapshotDir = t.TempDir()
+	g.adminToken = "secret"
+	t.Cleanup(g.shutdown)
+	return g
+}
+
+// testConn is the client end of a connection to a test game.
+type testConn struct {
+	t    testing.TB
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// connect opens an in-memory connection to g.
+func connect(t testing.TB, g *Game) *testConn {
+	t.Helper()
+	client, server := net.Pipe()
+	go g.handleConnection(server)
+	t.Cleanup(func() { client.Close() })
+	return &testConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// dial opens a connection to addr on the network.
+func dial(t testing.TB, network, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// listen serves g on a TCP port of the loopback interface and returns its
+// address.
+func listen(t testing.TB, g *Game) string {
+	t.Helper()
+	return serve(t, g, "tcp", "127.0.0.1:0")
+}
+
+// serve serves g at address on the network and returns the address it
+// listens on.
+func serve(t testing.TB, g *Game, network, address string) string {
+	t.Helper()
+	g.listenersMu.Lock()
+	n := len(g.listeners)
+	g.listenersMu.Unlock()
+
+	go g.startServer(network, address)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.listenersMu.Lock()
+		var addr string
+		if len(g.listeners) > n {
+			addr = g.listeners[n].Addr().String()
+		}
+		g.listenersMu.Unlock()
+		if addr != "" {
+			return addr
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("server didn't start listening")
+	return ""
+}
+
+// send writes each line followed by a newline.
+func (c *testConn) send(lines ...string) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	for _, line := range lines {
+		if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// readLine returns the next reply line without its newline.
+func (c *testConn) readLine() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// sync waits until all commands sent so far were handled and returns the
+// reply lines they produced.
+func (c *testConn) sync() []string {
+	c.t.Helper()
+	c.send("PING sync")
+	var lines []string
+	for {
+		line := c.readLine()
+		if line == "PONG sync" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// do sends the lines and returns the replies to them.
+func (c *testConn) do(lines ...string) []string {
+	c.t.Helper()
+	c.send(lines...)
+	return c.sync()
+}
+
+// pixel returns the canvas pixel at (x, y).
+func (g *Game) pixel(x, y int) color.RGBA {
+	return g.Snapshot().RGBAAt(x, y)
+}
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+)
+
+func TestPauseResume(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("AUTH secret", "PAUSE", "PX 1 1 ff0000")
+	g.headlessFrame()
+	if got := g.pixel(1, 1); got != (color.RGBA{}) {
+		t.Errorf("pixel drawn while paused: %v", got)
+	}
+
+	c.do("RESUME")
+	g.headlessFrame()
+	if got := g.pixel(1, 1); got != red {
+		t.Errorf("pixel after RESUME = %v, want %v", got, red)
+	}
+}
+
+func TestDrawPausedBeforeFirstFrame(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.paused.Store(true)
+	// there is no frame yet, and nothing must touch the screen
+	g.Draw(nil)
+}
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"PX 1 1 ff0000", []string{"PX", "1", "1", "ff0000"}},
+		{"PX\t1\t1\tff0000", []string{"PX", "1", "1", "ff0000"}},
+		{"PX  1  1  ff0000", []string{"PX", "1", "1", "ff0000"}},
+		{" PX 1 1 ff0000\r", []string{"PX", "1", "1", "ff0000"}},
+		{"PX 1\u00a01", []string{"PX", "1\u00a01"}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		got := splitFields(test.line)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("splitFields(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestIrregularSpacing(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("PX\t1\t1\tff0000", "PX  2  2  00ff00")
+	g.Render()
+	if got := g.pixel(1, 1); got != red {
+		t.Errorf("tab separated PX drew %v, want %v", got, red)
+	}
+	if got := g.pixel(2, 2); got != green {
+		t.Errorf("double spaced PX drew %v, want %v", got, green)
+	}
+}
+
+func TestControlBytesRejected(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.strict = true
+	c := connect(t, g)
+
+	got := c.do("PX 1 1 ff\x000000", "PX\t2\t2\tff0000\r")
+	if len(got) != 1 || got[0] != "ERROR control character in command" {
+		t.Fatalf("PX with an embedded NUL replied %q, want one error", got)
+	}
+	g.Render()
+	if got := g.pixel(1, 1); got != (color.RGBA{}) {
+		t.Errorf("PX with an embedded NUL drew %v", got)
+	}
+	if got := g.pixel(2, 2); got != red {
+		t.Errorf("PX with tabs and CR drew %v, want %v", got, red)
+	}
+}
+
+func TestSlowReaderDoesNotStall(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	// nothing reads the replies, so the writer blocks on the first one
+	for i := 0; i < outQueueSize/2; i++ {
+		c.send("PX 0 0")
+	}
+	c.send("PX 1 1 ff0000")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for g.Render().RGBAAt(1, 1) != red {
+		if time.Now().After(deadline) {
+			t.Fatal("a write behind unread replies was never applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the replies are all still delivered, in order
+	for i := 0; i < outQueueSize/2; i++ {
+		if got := c.readLine(); got != "PX 0 0 000000" {
+			t.Fatalf("reply %d = %q", i, got)
+		}
+	}
+}
+
+func TestPXRStaircase(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("PX 1 1 ff0000")
+	for i := 0; i < 3; i++ {
+		c.do("PXR 1 0 ff0000", "PXR 0 1 ff0000")
+	}
+	canvas := g.Render()
+
+	steps := []image.Point{{1, 1}, {2, 1}, {2, 2}, {3, 2}, {3, 3}, {4, 3}, {4, 4}}
+	for _, p := range steps {
+		if got := canvas.RGBAAt(p.X, p.Y); got != red {
+			t.Errorf("stair at %v = %v, want %v", p, got, red)
+		}
+	}
+	if got := canvas.RGBAAt(1, 2); got != (color.RGBA{}) {
+		t.Errorf("pixel below the staircase = %v, want untouched", got)
+	}
+}
+
+func TestPXRWithOffset(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	// PXR moves from the absolute position, the offset is applied only once
+	c.do("OFFSET 2 2", "PX 1 1 ff0000", "PXR 1 0 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(4, 3); got != green {
+		t.Errorf("PXR after OFFSET drew %v at (4,3), want %v", got, green)
+	}
+}
+
+func TestBanner(t *testing.T) {
+	g := newTestGame(t, 800, 600)
+	g.banner = true
+	c := connect(t, g)
+
+	// the banner arrives before anything was sent
+	want := "PIXELFLUT " + version + " 800x600"
+	if got := c.readLine(); got != want {
+		t.Errorf("first line = %q, want %q", got, want)
+	}
+	if got := c.do("SIZE"); len(got) != 1 || got[0] != "SIZE 800 600" {
+		t.Errorf("SIZE after the banner = %q", got)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	path := serve(t, g, "unix", filepath.Join(t.TempDir(), "pixelflut.sock"))
+	c := dial(t, "unix", path)
+
+	if got := c.do("SIZE"); len(got) != 1 || got[0] != "SIZE 10 10" {
+		t.Errorf("SIZE over the Unix socket = %q", got)
+	}
+	c.do("PX 3 4 ff0000")
+	if got := g.Render().RGBAAt(3, 4); got != red {
+		t.Errorf("pixel set over the Unix socket = %v, want %v", got, red)
+	}
+}
+
+func TestHoldFlush(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("HOLD", "PX 1 1 ff0000", "PX 2 2 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("held pixel visible before FLUSH: %v", got)
+	}
+
+	c.do("FLUSH")
+	canvas = g.Render()
+	if got := canvas.RGBAAt(1, 1); got != red {
+		t.Errorf("pixel after FLUSH = %v, want %v", got, red)
+	}
+	if got := canvas.RGBAAt(2, 2); got != green {
+		t.Errorf("pixel after FLUSH = %v, want %v", got, green)
+	}
+
+	// writes after FLUSH are no longer held
+	c.do("PX 3 3 0000ff")
+	if got := g.Render().RGBAAt(3, 3); got != blue {
+		t.Errorf("pixel after FLUSH = %v, want %v", got, blue)
+	}
+}
+
+func TestHeldWritesDiscardedOnDisconnect(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	c.do("HOLD", "PX 1 1 ff0000")
+	c.conn.Close()
+	if got := g.Render().RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("held pixel of a closed connection = %v, want it discarded", got)
+	}
+}
+
+func TestNegativeCoordinatesWithOffset(t *testing.T) {
+	g := newTestGame(t, 200, 200)
+	c := connect(t, g)
+
+	// the bounds check applies to the coordinate after OFFSET, not the raw one
+	c.do("OFFSET 100 100", "PX -50 -50 ff0000", "PX +10 -100 00ff00", "PX -101 0 0000ff")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(50, 50); got != red {
+		t.Errorf("PX -50 -50 after OFFSET 100 100 drew %v at (50,50), want %v", got, red)
+	}
+	if got := canvas.RGBAAt(110, 0); got != green {
+		t.Errorf("PX +10 -100 after OFFSET 100 100 drew %v at (110,0), want %v", got, green)
+	}
+	if got := c.do("OFFSET 0 0", "PX 199 100"); len(got) != 1 || got[0] != "PX 199 100 000000" {
+		t.Errorf("PX -101 0 outside the canvas wrapped or drew, PX 199 100 = %q", got)
+	}
+}
+
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"-16777216", -16777216, true},
+		{"16777216", 16777216, true},
+		{"16777217", 0, false},
+		{"-16777217", 0, false},
+		{"2147483648", 0, false},
+		{"-2147483649", 0, false},
+		{"4294967296", 0, false},
+		{"99999999999999999999", 0, false},
+		{"1e3", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseCoordinate(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseCoordinate(%q) = %d, %v; want %d, ok %v", test.s, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestPXRClampsPosition(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	c := connect(t, g)
+
+	// far beyond the edges the position sticks to ±maxCoordinate, so one
+	// step back lands on the canvas again
+	c.do("PX 0 1 000000")
+	for i := 0; i < 3; i++ {
+		c.send("PXR 16777216 0 000000")
+	}
+	c.do("PXR -16777216 0 ff0000")
+	for i := 0; i < 3; i++ {
+		c.send("PXR 0 -16777216 000000")
+	}
+	c.do("PXR 0 16777216 00ff00")
+
+	canvas := g.Render()
+	if got := canvas.RGBAAt(0, 1); got != red {
+		t.Errorf("PXR back from beyond the right edge drew %v at (0,1), want %v", got, red)
+	}
+	if got := canvas.RGBAAt(0, 0); got != green {
+		t.Errorf("PXR back from beyond the top edge drew %v at (0,0), want %v", got, green)
+	}
+}
+
+func TestPXNCenter(t *testing.T) {
+	g := newTestGame(t, 800, 600)
+	c := connect(t, g)
+
+	c.do("OFFSET 10 10", "PXN 0.5 0.5 ff0000", "PXN 1 1 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(400, 300); got != red {
+		t.Errorf("PXN 0.5 0.5 drew %v at the center, want %v", got, red)
+	}
+	// 1 is clamped to the last pixel, and OFFSET doesn't apply
+	if got := canvas.RGBAAt(799, 599); got != green {
+		t.Errorf("PXN 1 1 drew %v at the corner, want %v", got, green)
+	}
+}
+
+func TestScale(t *testing.T) {
+	g := newTestGame(t, 800, 600)
+	c := connect(t, g)
+
+	// a client made for a canvas twice as big reaches the last pixel
+	c.do("SCALE 0.5 0.5", "PX 1000 800 ff0000", "PX 1598 1198 0000ff")
+	// the offset is added after scaling
+	c.do("OFFSET 10 20", "PX 100 100 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(500, 400); got != red {
+		t.Errorf("PX 1000 800 after SCALE 0.5 0.5 drew %v at (500,400), want %v", got, red)
+	}
+	if got := canvas.RGBAAt(799, 599); got != blue {
+		t.Errorf("PX 1598 1198 after SCALE 0.5 0.5 drew %v at (799,599), want %v", got, blue)
+	}
+	if got := canvas.RGBAAt(60, 70); got != green {
+		t.Errorf("PX 100 100 after SCALE 0.5 0.5 and OFFSET 10 20 drew %v at (60,70), want %v", got, green)
+	}
+}
+
+func TestShutdownClosesConnections(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	addr := listen(t, g)
+	clients := []*testConn{dial(t, "tcp", addr), dial(t, "tcp", addr), connect(t, g)}
+	for _, c := range clients {
+		c.do("PX 1 1 ff0000")
+	}
+
+	g.shutdown()
+	for i, c := range clients {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.r.ReadByte(); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("client %d: read after shutdown = %v, want the connection closed", i, err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for g.stats.connections.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d handlers still running after shutdown", g.stats.connections.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("a new connection was accepted after shutdown")
+	}
+}
+
+func TestIntegerScale(t *testing.T) {
+	tests := []struct {
+		windowWidth, windowHeight, canvasWidth, canvasHeight, want int
+	}{
+		{1920, 1080, 640, 360, 3},
+		{1920, 1080, 800, 600, 1},
+		{1920, 1200, 640, 360, 3},
+		{1000, 1000, 300, 200, 3},
+		{1000, 300, 300, 200, 1},
+		// a window smaller than the canvas still shows it unscaled
+		{100, 100, 800, 600, 1},
+	}
+	for _, test := range tests {
+		if got := integerScale(test.windowWidth, test.windowHeight, test.canvasWidth, test.canvasHeight); got != test.want {
+			t.Errorf("integerScale(%d, %d, %d, %d) = %d, want %d",
+				test.windowWidth, test.windowHeight, test.canvasWidth, test.canvasHeight, got, test.want)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.startTime = time.Now().Add(-90 * time.Second)
+	c := connect(t, g)
+
+	got := c.do("UPTIME")
+	want := fmt.Sprintf("UPTIME 90 %s", g.startTime.UTC().Format(time.RFC3339))
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("UPTIME = %q, want %q", got, want)
+	}
+}
+
+// captureLog collects what is logged until the test ends.
+func captureLog(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer that may be written from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a call to fakeClock.After that hasn't fired yet.
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+// advance moves the clock forward by d, firing the waits that end by then.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiters = kept
+}
+
+// waitForWaiters waits until n calls to After wait for the clock.
+func (c *fakeClock) waitForWaiters(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		waiting := len(c.waiters)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls wait for the clock, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowReplyLogged(t *testing.T) {
+	logged := captureLog(t)
+	g := newTestGame(t, 10, 10)
+	g.slowThreshold = 20 * time.Millisecond
+	c := connect(t, g)
+
+	// the reply can't be sent until the client reads it
+	c.send("SIZE")
+	time.Sleep(50 * time.Millisecond)
+	if got := c.readLine(); got != "SIZE 10 10" {
+		t.Fatalf("SIZE replied %q", got)
+	}
+	c.sync()
+	if !strings.Contains(logged.String(), `Slow reply to pipe took`) || !strings.Contains(logged.String(), `"SIZE 10 10\n"`) {
+		t.Errorf("log = %q, want the slow reply", logged.String())
+	}
+
+	// replies read right away aren't logged
+	before := logged.String()
+	c.do("SIZE")
+	if after := logged.String(); after != before {
+		t.Errorf("a prompt reply was logged: %q", strings.TrimPrefix(after, before))
+	}
+}
+
+func TestDisconnectSummary(t *testing.T) {
+	logged := captureLog(t)
+	g := newTestGame(t, 10, 10)
+	g.connStats = true
+	c := connect(t, g)
+
+	c.do("PX 1 1 ff0000", "PX 1 1", "AUTH wrong")
+	c.conn.Close()
+
+	// the sync's PING is a command, too
+	want := ": 4 commands, 1 pixel writes, 1 reads, 1 errors"
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logged.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("log = %q, want a summary ending in %q", logged.String(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(logged.String(), "Connection from pipe closed after ") {
+		t.Errorf("log = %q, want the summary to name the client", logged.String())
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.snapshotOnShutdown = true
+	addr := listen(t, g)
+	stopped := make(chan struct{})
+	go func() {
+		g.runHeadless()
+		close(stopped)
+	}()
+
+	c := dial(t, "tcp", addr)
+	got := c.do("AUTH wrong", "SHUTDOWN")
+	if len(got) != 2 || got[0] != "ERROR unauthorized" || got[1] != "ERROR unauthorized" {
+		t.Fatalf("SHUTDOWN with an invalid token replied %q", got)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("SHUTDOWN with an invalid token stopped the server")
+	default:
+	}
+
+	c.do("AUTH secret")
+	c.send("SHUTDOWN")
+	if got := c.readLine(); got != "SHUTDOWN" {
+		t.Fatalf("SHUTDOWN replied %q", got)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SHUTDOWN didn't stop the render loop")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("the server still accepts connections after SHUTDOWN")
+	}
+	snapshots, _ := filepath.Glob(filepath.Join(g.snapshotDir, "auto-*.png"))
+	if len(snapshots) != 1 {
+		t.Errorf("SHUTDOWN saved %d snapshots, want 1", len(snapshots))
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	g.snapshotOnShutdown = true
+	clock := newFakeClock()
+	g.clock = clock
+	go g.shutdownAfter(time.Hour)
+
+	clock.waitForWaiters(t, 1)
+	clock.advance(time.Hour - time.Second)
+	select {
+	case <-g.done:
+		t.Fatal("shut down before -max-runtime passed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.advance(time.Second)
+	select {
+	case <-g.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't shut down after -max-runtime")
+	}
+	snapshots, _ := filepath.Glob(filepath.Join(g.snapshotDir, "auto-*.png"))
+	if len(snapshots) != 1 {
+		t.Errorf("saved %d snapshots on shutdown, want 1", len(snapshots))
+	}
+}
+
+func TestMinActivity(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	g.minActivity = 3
+	clock := newFakeClock()
+	g.clock = clock
+	active, idle := connect(t, g), connect(t, g)
+
+	active.do("PX 0 0 ff0000", "PX 1 0 ff0000")
+	idle.do()
+	clock.waitForWaiters(t, 2)
+	clock.advance(activityWindow)
+
+	// the sync PING counts too, so the active one sent 3 commands
+	idle.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := idle.r.ReadString('\n'); err == nil {
+		t.Error("a connection below -min-activity wasn't closed")
+	}
+	clock.waitForWaiters(t, 1)
+	if got := active.do("PX 0 1 ff0000"); len(got) != 0 {
+		t.Errorf("active connection replied %q", got)
+	}
+}
+
+func TestFirstLineTimeout(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	g.firstLineTimeout = 100 * time.Millisecond
+	dribbling, prompt := connect(t, g), connect(t, g)
+
+	// a byte now and then, but never a newline
+	go func() {
+		for _, b := range []byte("PX 0 0 ff0000") {
+			if _, err := dribbling.conn.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	prompt.do("PX 1 1 ff0000")
+
+	dribbling.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := dribbling.r.ReadString('\n'); !errors.Is(err, io.EOF) {
+		t.Errorf("reading from the dribbling connection = %v, want it closed", err)
+	}
+	// past the timeout, but it sent a line in time
+	time.Sleep(150 * time.Millisecond)
+	prompt.do("PX 1 1 00ff00")
+}
+
+func TestBoundsAfterOffset(t *testing.T) {
+	g := newTestGame(t, 100, 80)
+	c := connect(t, g)
+
+	got := c.do("OFFSET 10 20", "BOUNDS")
+	if len(got) != 1 || got[0] != "BOUNDS -10 -20 100 80" {
+		t.Fatalf("BOUNDS after OFFSET replied %q", got)
+	}
+
+	// the corners of the bounds are the corners of the canvas
+	c.do("PX -10 -20 ff0000", "PX 89 59 00ff00")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(0, 0); got != red {
+		t.Errorf("top left corner = %v, want %v", got, red)
+	}
+	if got := canvas.RGBAAt(99, 79); got != green {
+		t.Errorf("bottom right corner = %v, want %v", got, green)
+	}
+}
+
+func BenchmarkMultiLineReply(b *testing.B) {
+	const lines = 256
+	g := newTestGame(b, 16, 16)
+	c := dial(b, "tcp", listen(b, g))
+	var batch bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&batch, "PX %d %d\n", i%16, i/16)
+	}
+
+	b.SetBytes(int64(batch.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.conn.Write(batch.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < lines; j++ {
+			if _, err := c.r.ReadString('\n'); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRotateKeepsCoordinates(t *testing.T) {
+	for rotate := 0; rotate < 4; rotate++ {
+		g := newTestGame(t, 4, 2)
+		g.rotate = rotate
+		c := connect(t, g)
+
+		// only the display turns, clients still see a 4x2 canvas
+		c.do("PX 3 0 ff0000")
+		g.Render()
+		got := c.do("SIZE", "PX 3 0")
+		if len(got) != 2 || got[0] != "SIZE 4 2" || got[1] != "PX 3 0 ff0000" {
+			t.Errorf("rotated %d times, SIZE and PX replied %q", rotate, got)
+		}
+		if got := g.pixel(3, 0); got != red {
+			t.Errorf("rotated %d times, canvas pixel = %v, want %v", rotate, got, red)
+		}
+
+		width, height := g.displaySize(4, 2)
+		if rotate%2 == 1 && (width != 2 || height != 4) {
+			t.Errorf("rotated %d times, display size = %dx%d, want 2x4", rotate, width, height)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	// one pixel a second at most, which PING must not count against
+	g.limiters = newRateLimiters(1, 16)
+	c := dial(t, "tcp", listen(t, g))
+
+	got := c.do("PING", "PING 1697040000123", "PING a b", "PING x", "PING x")
+	want := []string{"PONG", "PONG 1697040000123", "PONG x", "PONG x"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("PINGs replied %q, want %q", got, want)
+	}
+}
+
+func TestConns(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	addr := listen(t, g)
+	a := dial(t, "tcp", addr)
+	b := dial(t, "tcp", addr)
+	b.sync()
+
+	if got := a.do("CONNS"); len(got) != 1 || got[0] != "CONNS 2" {
+		t.Errorf("CONNS with two connections = %q, want CONNS 2", got)
+	}
+
+	b.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := a.do("CONNS")
+		if len(got) == 1 && got[0] == "CONNS 1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("CONNS after closing one connection = %q, want CONNS 1", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// memoryEstimate is the projected memory use of the canvas and the buffers
+// that grow with it or with the configuration, in bytes.
+type memoryEstimate struct {
+	items []memoryItem
+	// perConnection is what every open connection adds at most, not
+	// counting replies waiting to be sent
+	perConnection uint64
+}
+
+type memoryItem struct {
+	name  string
+	bytes uint64
+}
+
+func (e *memoryEstimate) add(name string, bytes uint64) {
+	e.items = append(e.items, memoryItem{name, bytes})
+}
+
+// total returns the projected memory use without any connections.
+func (e *memoryEstimate) total() uint64 {
+	var total uint64
+	for _, item := range e.items {
+		total += item.bytes
+	}
+	return total
+}
+
+// log logs the projected memory use, item by item.
+func (e *memoryEstimate) log() {
+	parts := make([]string, len(e.items))
+	for i, item := range e.items {
+		parts[i] = item.name + " " + formatBytes(item.bytes)
+	}
+	log.Printf("Projected memory: %s (%s), plus up to %s per connection",
+		formatBytes(e.total()), strings.Join(parts, ", "), formatBytes(e.perConnection))
+}
+
+// check fails if the projected memory use exceeds budget, or if the budget
+// leaves room for no connection at all. A zero budget disables the check.
+func (e *memoryEstimate) check(budget uint64) error {
+	if budget == 0 {
+		return nil
+	}
+	total := e.total()
+	if total+e.perConnection > budget {
+		return fmt.Errorf("projected memory of %s plus %s per connection exceeds -max-memory %s; shrink the canvas or -queue-size, or disable -track-owners, -track-changes or -pixel-cooldown-frames",
+			formatBytes(total), formatBytes(e.perConnection), formatBytes(budget))
+	}
+	log.Println("-max-memory leaves room for about", (budget-total)/e.perConnection, "connections")
+	return nil
+}
+
+// parseByteSize parses a size like 512M or 2G, with binary K, M and G
+// suffixes, or plain bytes.
+func parseByteSize(s string) (uint64, error) {
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid size " + strconv.Quote(s))
+	}
+	if n > (1<<64-1)>>shift {
+		return 0, errors.New("size too large")
+	}
+	return n << shift, nil
+}
+
+// formatBytes formats n in the largest binary unit that keeps it at least 1.
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// pixelUpdateSize is the size of a queued pixel update.
+const pixelUpdateSize = uint64(unsafe.Sizeof(PixelUpdate{}))
+
+// connectionMemory returns what a connection needs at most for its read
+// buffer, its write buffer and its empty reply queue.
+func connectionMemory(maxLine int) uint64 {
+	return uint64(maxLine) + 32*1024 + outQueueSize*uint64(unsafe.Sizeof(reply{}))
+}
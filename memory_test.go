@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	// 16384x16384 is 256M pixels, a gigabyte of canvas alone
+	const pixels = 16384 * 16384
+	var memory memoryEstimate
+	memory.add("canvas", pixels*4*3)
+	memory.add("queue", 2*65536*pixelUpdateSize)
+	memory.perConnection = connectionMemory(1024)
+
+	budget, err := parseByteSize("4G")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.check(budget); err != nil {
+		t.Fatalf("check without owner tracking = %v", err)
+	}
+
+	memory.add("owners", pixels*12)
+	err = memory.check(budget)
+	if err == nil {
+		t.Fatal("check with owner tracking on a 16384x16384 canvas passed a 4G budget")
+	}
+	for _, want := range []string{"exceeds -max-memory 4.0G", "-track-owners"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("check error %q doesn't mention %q", err, want)
+		}
+	}
+
+	if err := memory.check(0); err != nil {
+		t.Errorf("check without a budget = %v", err)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint64
+		ok   bool
+	}{
+		{"4096", 4096, true},
+		{"512K", 512 << 10, true},
+		{"256M", 256 << 20, true},
+		{"2G", 2 << 30, true},
+		{"1T", 0, false},
+		{"-1M", 0, false},
+		{"17179869184G", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, ok %v", test.s, got, err, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"net"
+	"time"
+)
+
+// mirrorQueueSize is the number of changed canvas regions buffered for the
+// mirror. Changes beyond that are dropped while the mirror is slow or down.
+const mirrorQueueSize = 65536
+
+// mirrorBatch bounds the regions read from the canvas at once, so a slow
+// mirror doesn't hold canvasMu for long.
+const mirrorBatch = 4096
+
+// mirror forwards changes of the canvas to another Pixelflut server. It
+// queues the changed regions, not the writes, and sends what the canvas
+// holds there, so blend modes, fades, COPY, FILTER and everything else that
+// draws arrive as their result. Translucent pixels are sent with their
+// alpha and match only where the other server's pixel is still transparent.
+type mirror struct {
+	addr    string
+	regions chan image.Rectangle
+}
+
+func newMirror(addr string) *mirror {
+	return &mirror{
+		addr:    addr,
+		regions: make(chan image.Rectangle, mirrorQueueSize),
+	}
+}
+
+// forward queues the changed region r for the mirror without blocking,
+// dropping it if the queue is full.
+func (m *mirror) forward(r image.Rectangle) {
+	select {
+	case m.regions <- r:
+	default:
+	}
+}
+
+// run connects to the mirror and sends it the queued changes of g's canvas
+// as PX commands until shutdown, reconnecting with a jittered backoff
+// whenever the connection fails.
+func (m *mirror) run(g *Game) {
+	backoff := time.Second
+	for {
+		conn, err := net.DialTimeout("tcp", m.addr, 5*time.Second)
+		if err == nil {
+			log.Println("Mirroring to", m.addr)
+			backoff = time.Second
+			err = m.send(conn, g)
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		log.Println("Mirror", m.addr, "unavailable:", err)
+
+		select {
+		case <-g.done:
+			return
+		case <-time.After(jitter(backoff)):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// send writes the pixels of the queued regions to conn, flushing whenever
+// the queue runs empty. The pixels are copied under canvasMu and written
+// after releasing it. It returns nil once g shuts down.
+func (m *mirror) send(conn net.Conn, g *Game) error {
+	w := bufio.NewWriterSize(conn, 64*1024)
+	regions := make([]image.Rectangle, 0, mirrorBatch)
+	images := make([]*image.RGBA, 0, mirrorBatch)
+	for {
+		select {
+		case <-g.done:
+			return w.Flush()
+		case r := <-m.regions:
+			regions = append(regions[:0], r)
+		}
+	batch:
+		for len(regions) < mirrorBatch {
+			select {
+			case r := <-m.regions:
+				regions = append(regions, r)
+			default:
+				break batch
+			}
+		}
+
+		images = images[:0]
+		g.canvasMu.RLock()
+		for _, r := range regions {
+			r = r.Intersect(g.canvas.Rect)
+			if r.Empty() {
+				continue
+			}
+			img := image.NewRGBA(r)
+			draw.Draw(img, r, g.canvas, r.Min, draw.Src)
+			images = append(images, img)
+		}
+		g.canvasMu.RUnlock()
+
+		for _, img := range images {
+			r := img.Rect
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					c := color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA)
+					if c.A == 255 {
+						fmt.Fprintf(w, "PX %d %d %02x%02x%02x\n", x, y, c.R, c.G, c.B)
+					} else {
+						fmt.Fprintf(w, "PX %d %d %02x%02x%02x%02x\n", x, y, c.R, c.G, c.B, c.A)
+					}
+				}
+			}
+		}
+
+		if len(m.regions) == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	primary, replica := newTestGame(t, 10, 10), newTestGame(t, 10, 10)
+	primary.mirror = newMirror(listen(t, replica))
+	go primary.mirror.run(primary)
+
+	c := connect(t, primary)
+	c.do("PX 1 2 ff0000", "PX 3 4 00ff0080", "PX 5 6 ff0000")
+	primary.Render()
+	// the replica gets the resulting pixels, not a PX of the input color
+	c.do("BLEND add", "PX 5 6 0000ff", "BLEND normal")
+	c.do("AUTH secret", "COPY 1 2 1 1 7 8")
+	renderTask(t, primary)
+
+	want := map[[2]int]color.RGBA{
+		{1, 2}: red,
+		// translucent writes arrive with their alpha
+		{3, 4}: {0, 128, 0, 128},
+		{5, 6}: {0xff, 0, 0xff, 0xff},
+		{7, 8}: red,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		canvas := replica.Render()
+		mirrored := true
+		for p, c := range want {
+			mirrored = mirrored && canvas.RGBAAt(p[0], p[1]) == c
+		}
+		if mirrored {
+			return
+		}
+		if time.Now().After(deadline) {
+			for p, c := range want {
+				if got := canvas.RGBAAt(p[0], p[1]); got != c {
+					t.Errorf("replica pixel %v = %v, want %v", p, got, c)
+				}
+			}
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ownerTracker remembers which client last wrote each pixel, and when.
+// Clients are identified by small integer IDs so the per-pixel cost stays at
+// 12 bytes; ID 0 means nobody.
+type ownerTracker struct {
+	mu    sync.Mutex
+	ids   map[string]uint32
+	addrs []string
+
+	// pixels and times are indexed like the canvas and guarded by canvasMu
+	rect   image.Rectangle
+	pixels []uint32
+	times  []int64 // unix seconds
+}
+
+func newOwnerTracker(rect image.Rectangle) *ownerTracker {
+	return &ownerTracker{
+		ids:    make(map[string]uint32),
+		addrs:  []string{""},
+		rect:   rect,
+		pixels: make([]uint32, rect.Dx()*rect.Dy()),
+		times:  make([]int64, rect.Dx()*rect.Dy()),
+	}
+}
+
+// id returns the ID for the client address addr, assigning one if needed.
+func (t *ownerTracker) id(addr string) uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id, ok := t.ids[addr]
+	if !ok {
+		id = uint32(len(t.addrs))
+		t.ids[addr] = id
+		t.addrs = append(t.addrs, addr)
+	}
+	return id
+}
+
+// addr returns the client address for id.
+func (t *ownerTracker) addr(id uint32) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.addrs[id]
+}
+
+// record notes owner as the last writer of (x, y). The caller must hold
+// canvasMu for writing.
+func (t *ownerTracker) record(x, y int, owner uint32) {
+	if !(image.Point{x, y}.In(t.rect)) {
+		return
+	}
+	i := (y-t.rect.Min.Y)*t.rect.Dx() + x - t.rect.Min.X
+	t.pixels[i] = owner
+	t.times[i] = time.Now().Unix()
+}
+
+// lookup returns the last writer of (x, y) and when it wrote. The caller must
+// hold canvasMu.
+func (t *ownerTracker) lookup(x, y int) (owner uint32, at time.Time) {
+	if !(image.Point{x, y}.In(t.rect)) {
+		return 0, time.Time{}
+	}
+	i := (y-t.rect.Min.Y)*t.rect.Dx() + x - t.rect.Min.X
+	return t.pixels[i], time.Unix(t.times[i], 0)
+}
+
+// resize reallocates the per-pixel buffers for rect, keeping the entries in
+// the overlap. The caller must hold canvasMu for writing.
+func (t *ownerTracker) resize(rect image.Rectangle) {
+	pixels := make([]uint32, rect.Dx()*rect.Dy())
+	times := make([]int64, rect.Dx()*rect.Dy())
+
+	overlap := rect.Intersect(t.rect)
+	for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+		from := (y-t.rect.Min.Y)*t.rect.Dx() + overlap.Min.X - t.rect.Min.X
+		to := (y-rect.Min.Y)*rect.Dx() + overlap.Min.X - rect.Min.X
+		copy(pixels[to:to+overlap.Dx()], t.pixels[from:])
+		copy(times[to:to+overlap.Dx()], t.times[from:])
+	}
+
+	t.rect, t.pixels, t.times = rect, pixels, times
+}
+
+// reset forgets all recorded writers. The caller must hold canvasMu for
+// writing.
+func (t *ownerTracker) reset() {
+	clear(t.pixels)
+	clear(t.times)
+}
+
+// ownerMap returns an image of the tracked area coloring every pixel by its
+// last writer, black where nobody wrote. The colors are derived from a hash
+// of the address, so a client keeps its color across maps. The caller must
+// hold canvasMu.
+func (t *ownerTracker) ownerMap() *image.RGBA {
+	t.mu.Lock()
+	colors := make([]color.RGBA, len(t.addrs))
+	for id, addr := range t.addrs[1:] {
+		h := fnv.New32a()
+		h.Write([]byte(addr))
+		sum := h.Sum32()
+		// keep every channel away from black, which stands for nobody
+		colors[id+1] = color.RGBA{uint8(sum>>16) | 0x40, uint8(sum>>8) | 0x40, uint8(sum) | 0x40, 255}
+	}
+	t.mu.Unlock()
+	colors[0] = color.RGBA{A: 255}
+
+	img := image.NewRGBA(t.rect)
+	for i, owner := range t.pixels {
+		c := colors[0]
+		// IDs handed out after the colors were gathered have no pixels yet
+		if int(owner) < len(colors) {
+			c = colors[owner]
+		}
+		img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// exportOwnerMap writes the owner map as a PNG file to the snapshot
+// directory and returns its path.
+func (g *Game) exportOwnerMap() (string, error) {
+	g.canvasMu.RLock()
+	img := g.owners.ownerMap()
+	g.canvasMu.RUnlock()
+
+	if err := os.MkdirAll(g.snapshotDir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(g.snapshotDir, "owners-"+time.Now().Format("20060102-150405.000")+".png")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWho(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.owners = newOwnerTracker(g.canvas.Rect)
+	c := dial(t, "tcp", listen(t, g))
+
+	c.do("PX 3 4 ff0000")
+	g.Render()
+
+	got := c.do("AUTH secret", "WHO 3 4", "WHO 5 5")
+	if len(got) != 3 {
+		t.Fatalf("WHO replied %q", got)
+	}
+	if fields := strings.Fields(got[1]); len(fields) != 5 || fields[3] != "127.0.0.1" {
+		t.Errorf("WHO of a written pixel = %q, want the writer 127.0.0.1", got[1])
+	}
+	if got[2] != "WHO 5 5 none" {
+		t.Errorf("WHO of an untouched pixel = %q", got[2])
+	}
+}
+
+func TestOwnerMap(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	g.owners = newOwnerTracker(g.canvas.Rect)
+	g.owners.record(0, 0, g.owners.id("10.0.0.1"))
+	g.owners.record(1, 0, g.owners.id("10.0.0.1"))
+	g.owners.record(2, 0, g.owners.id("10.0.0.2"))
+	c := connect(t, g)
+
+	got := c.do("AUTH secret", "OWNERMAP")
+	if len(got) != 2 || !strings.HasPrefix(got[1], "OWNERMAP ") {
+		t.Fatalf("OWNERMAP replied %q", got)
+	}
+	f, err := os.Open(strings.TrimPrefix(got[1], "OWNERMAP "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second, nobody := img.At(0, 0), img.At(2, 0), img.At(3, 3)
+	if img.At(1, 0) != first {
+		t.Errorf("pixels of one writer have colors %v and %v", first, img.At(1, 0))
+	}
+	if first == second {
+		t.Errorf("pixels of two writers both have color %v", first)
+	}
+	if r, g, b, _ := nobody.RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Errorf("unwritten pixel has color %v, want black", nobody)
+	}
+	for _, c := range []color.Color{first, second} {
+		if r, g, b, _ := c.RGBA(); r == 0 && g == 0 && b == 0 {
+			t.Errorf("written pixel has color %v, like nobody", c)
+		}
+	}
+}
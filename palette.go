@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxPaletteName bounds the length of a palette color name.
+const maxPaletteName = 32
+
+// palette maps color names, set with PALETTE, to premultiplied colors that
+// are accepted wherever a COLOR is. Names are case-insensitive. The zero
+// value is an empty palette.
+type palette struct {
+	mu     sync.RWMutex
+	colors map[string]color.RGBA
+}
+
+// lookup returns the color named name.
+func (p *palette) lookup(name string) (color.RGBA, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, ok := p.colors[strings.ToLower(name)]
+	return c, ok
+}
+
+// empty reports whether no color has been named yet.
+func (p *palette) empty() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.colors) == 0
+}
+
+// set parses assignments like name=rrggbb and applies them all together, or
+// none of them if any is invalid. Names must not be valid colors themselves,
+// since those would never be looked up.
+func (p *palette) set(assignments []string) error {
+	colors := make(map[string]color.RGBA, len(assignments))
+	for _, assignment := range assignments {
+		name, spec, ok := strings.Cut(assignment, "=")
+		name = strings.ToLower(name)
+		if !ok || !validPaletteName(name) {
+			return fmt.Errorf("invalid palette name in %q", assignment)
+		}
+		c, ok := parseColor(spec)
+		if !ok {
+			return fmt.Errorf("invalid color in %q", assignment)
+		}
+		colors[name] = c
+	}
+	if len(colors) == 0 {
+		return errors.New("nothing to set")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.colors == nil {
+		p.colors = make(map[string]color.RGBA)
+	}
+	for name, c := range colors {
+		p.colors[name] = c
+	}
+	return nil
+}
+
+// list returns the palette as sorted name=color assignments that set
+// accepts.
+func (p *palette) list() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := make([]string, 0, len(p.colors))
+	for name, c := range p.colors {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		spec := fmt.Sprintf("%02x%02x%02x", n.R, n.G, n.B)
+		if n.A != 255 {
+			spec += fmt.Sprintf("%02x", n.A)
+		}
+		entries = append(entries, name+"="+spec)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// validPaletteName reports whether name, in lower case, may be used for a
+// palette color.
+func validPaletteName(name string) bool {
+	if name == "" || len(name) > maxPaletteName {
+		return false
+	}
+	if _, ok := parseColor(name); ok {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
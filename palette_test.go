@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestPalette(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+
+	got := c.do("AUTH secret", "PALETTE set Sky=87ceeb glass=ff000080", "PALETTE list")
+	want := []string{"AUTH OK", "PALETTE OK", "PALETTE glass=ff000080 sky=87ceeb"}
+	if len(got) != len(want) {
+		t.Fatalf("PALETTE replied %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reply %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	c.do("PX 1 2 sky", "PX 2 2 SKY")
+	g.Render()
+	sky := color.RGBA{0x87, 0xce, 0xeb, 0xff}
+	for _, x := range []int{1, 2} {
+		if got := g.pixel(x, 2); got != sky {
+			t.Errorf("PX %d 2 sky drew %v, want %v", x, got, sky)
+		}
+	}
+
+	// one invalid assignment sets nothing, and names can't shadow colors
+	for _, line := range []string{"PALETTE set sea=2e8b57 bad=xyz", "PALETTE set ff0000=00ff00"} {
+		if got := c.do(line); len(got) != 1 || got[0][:6] != "ERROR " {
+			t.Errorf("%s replied %q, want an error", line, got)
+		}
+	}
+	if _, ok := g.palette.lookup("sea"); ok {
+		t.Error("sea was named despite an invalid assignment beside it")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+)
+
+// handleDeltaPixels reads n bytes of PXD records from r and writes their
+// pixels. Each record is the x and y distance to the previous pixel as
+// zigzag varints, then the straight RGBA color. The first record is relative
+// to the last pixel set, like PXR. A horizontal run thus costs 6 bytes per
+// pixel. The payload is always consumed completely.
+func (g *Game) handleDeltaPixels(r io.Reader, n int64, state *connState) error {
+	payload := io.LimitReader(r, n)
+	defer io.Copy(io.Discard, payload)
+	records := bufio.NewReader(payload)
+
+	x, y := state.lastX, state.lastY
+	for {
+		dx, err := binary.ReadVarint(records)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New("truncated PXD record")
+		}
+		dy, err := binary.ReadVarint(records)
+		if err != nil {
+			return errors.New("truncated PXD record")
+		}
+		var rgba [4]byte
+		if _, err := io.ReadFull(records, rgba[:]); err != nil {
+			return errors.New("truncated PXD record")
+		}
+
+		// keep the running position within what PixelUpdate can hold
+		if dx < -maxCoordinate || dx > maxCoordinate || dy < -maxCoordinate || dy > maxCoordinate {
+			return errors.New("PXD distance out of range")
+		}
+		x, y = x+int(dx), y+int(dy)
+		if x < -2*maxCoordinate || x > 2*maxCoordinate || y < -2*maxCoordinate || y > 2*maxCoordinate {
+			return errors.New("PXD position out of range")
+		}
+
+		c := color.NRGBA{rgba[0], rgba[1], rgba[2], rgba[3]}
+		g.writePixel(state, x, y, color.RGBAModel.Convert(c).(color.RGBA))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"testing"
+)
+
+// pxdRecord appends a PXD record to b.
+func pxdRecord(b []byte, dx, dy int, rgba ...byte) []byte {
+	b = binary.AppendVarint(b, int64(dx))
+	b = binary.AppendVarint(b, int64(dy))
+	return append(b, rgba...)
+}
+
+func TestDeltaPixelsRun(t *testing.T) {
+	g := newTestGame(t, 8, 4)
+	c := connect(t, g)
+
+	// relative to the last pixel set, then a horizontal run to the right
+	c.do("PX 1 2 0000ff")
+	payload := pxdRecord(nil, 1, -1, 255, 0, 0, 255)
+	for i := 0; i < 3; i++ {
+		payload = pxdRecord(payload, 1, 0, 0, 255, 0, 255)
+	}
+	if len(payload) != 4*6 {
+		t.Errorf("a run of 4 pixels took %d bytes, want 6 each", len(payload))
+	}
+	c.send(fmt.Sprintf("PXD %d", len(payload)))
+	c.conn.Write(payload)
+	if got := c.sync(); len(got) != 0 {
+		t.Fatalf("PXD replied %q", got)
+	}
+
+	canvas := g.Render()
+	for x, want := range map[int]color.RGBA{2: red, 3: green, 4: green, 5: green} {
+		if got := canvas.RGBAAt(x, 1); got != want {
+			t.Errorf("pixel (%d, 1) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestDeltaPixelsTruncated(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+
+	// the color of the second record is cut short
+	payload := pxdRecord(nil, 1, 1, 255, 0, 0, 255)
+	payload = pxdRecord(payload, 1, 0, 0, 255)
+	c.send(fmt.Sprintf("PXD %d", len(payload)))
+	c.conn.Write(payload)
+	if got := c.sync(); len(got) != 1 || got[0] != "ERROR truncated PXD record" {
+		t.Errorf("truncated PXD replied %q", got)
+	}
+	if got := g.Render().RGBAAt(1, 1); got != red {
+		t.Errorf("pixel of the complete record = %v, want %v", got, red)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random is the single source of randomness for all features, so that a
+// fixed -seed makes them reproducible. *rand.Rand isn't safe for concurrent
+// use, hence randomMu.
+var (
+	randomMu sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// setRandomSource replaces the shared source of randomness.
+func setRandomSource(src rand.Source) {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+
+	random = rand.New(src)
+}
+
+// randomIntn returns a random int in [0, n) from the shared source.
+func randomIntn(n int) int {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+
+	return random.Intn(n)
+}
+
+// jitter returns a random duration between d/2 and d, in whole milliseconds,
+// so that retries after a common failure spread out.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2 / time.Millisecond
+	return (half + time.Duration(randomIntn(int(half)+1))) * time.Millisecond
+}
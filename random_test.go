@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// jitters returns n jittered backoffs drawn after seeding with seed.
+func jitters(seed int64, n int) []time.Duration {
+	setRandomSource(rand.NewSource(seed))
+	backoffs := make([]time.Duration, n)
+	for i := range backoffs {
+		backoffs[i] = jitter(time.Minute)
+	}
+	return backoffs
+}
+
+func TestSeedIsReproducible(t *testing.T) {
+	defer setRandomSource(rand.NewSource(time.Now().UnixNano()))
+
+	a, b := jitters(42, 20), jitters(42, 20)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("backoff %d with the same seed: %v and %v", i, a[i], b[i])
+		}
+	}
+
+	other := jitters(43, 20)
+	same := true
+	for i := range a {
+		same = same && a[i] == other[i]
+	}
+	if same {
+		t.Error("different seeds gave the same backoffs")
+	}
+}
+
+func TestJitterRange(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := jitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jitter(1s) = %v, want between 500ms and 1s", d)
+		}
+	}
+}
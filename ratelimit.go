@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// rateLimiters hands out a token bucket per client IP. The number of buckets
+// is bounded; when it is full the least recently used bucket is evicted, so a
+// flood of spoofed or short-lived sources can't grow it without limit.
+type rateLimiters struct {
+	mu sync.Mutex
+
+	rate       float64 // tokens per second
+	burst      float64
+	maxEntries int
+
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiters returns limiters that allow rate writes per second per
+// client, tracking at most maxEntries clients, and at least one.
+func newRateLimiters(rate float64, maxEntries int) *rateLimiters {
+	maxEntries = max(maxEntries, 1)
+	return &rateLimiters{
+		rate:       rate,
+		burst:      rate,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// allow reports whether the client identified by key may spend one token at
+// time now.
+func (l *rateLimiters) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bucket *tokenBucket
+	if element, ok := l.entries[key]; ok {
+		l.order.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+		bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+		if bucket.tokens > l.burst {
+			bucket.tokens = l.burst
+		}
+		bucket.last = now
+	} else {
+		if l.order.Len() >= l.maxEntries {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.entries, oldest.Value.(*tokenBucket).key)
+		}
+		bucket = &tokenBucket{key: key, tokens: l.burst, last: now}
+		l.entries[key] = l.order.PushFront(bucket)
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// cooldowns allows each client IP a single pixel write per period. Clients
+// are kept in the order of their last write, so those whose cooldown is over
+// are dropped from the back as writes come in. The number of clients is
+// bounded like that of rateLimiters; when it is full the client that wrote
+// longest ago is evicted, and may write again early.
+type cooldowns struct {
+	mu sync.Mutex
+
+	period     time.Duration
+	maxEntries int
+
+	entries map[string]*list.Element
+	order   *list.List // front is the most recent write
+}
+
+type cooldownEntry struct {
+	key  string
+	last time.Time
+}
+
+// newCooldowns returns cooldowns of period, tracking at most maxEntries
+// clients, and at least one.
+func newCooldowns(period time.Duration, maxEntries int) *cooldowns {
+	return &cooldowns{
+		period:     period,
+		maxEntries: max(maxEntries, 1),
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// allow records a write by key at time now if its cooldown has passed.
+// Otherwise it returns false and the time left until the next write.
+func (c *cooldowns) allow(key string, now time.Time) (ok bool, remaining time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for oldest := c.order.Back(); oldest != nil; oldest = c.order.Back() {
+		entry := oldest.Value.(*cooldownEntry)
+		if now.Sub(entry.last) < c.period {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+	}
+
+	if element, found := c.entries[key]; found {
+		return false, c.period - now.Sub(element.Value.(*cooldownEntry).last)
+	}
+	if c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cooldownEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&cooldownEntry{key: key, last: now})
+	return true, 0
+}
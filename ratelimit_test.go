@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestRateLimitersEvictOldest(t *testing.T) {
+	l := newRateLimiters(1, 2)
+	now := time.Now()
+
+	// spend the only token of a and b, then touch a again
+	l.allow("a", now)
+	l.allow("b", now)
+	if l.allow("a", now) {
+		t.Fatal("a was allowed a second write without a token")
+	}
+
+	// c evicts b, the least recently used, which then starts over
+	l.allow("c", now)
+	if _, ok := l.entries["b"]; ok {
+		t.Error("b wasn't evicted")
+	}
+	if _, ok := l.entries["a"]; !ok {
+		t.Error("a was evicted although b was older")
+	}
+	if !l.allow("b", now) {
+		t.Error("evicted b didn't get a fresh bucket")
+	}
+}
+
+func TestRateLimitersNoEntries(t *testing.T) {
+	for _, entries := range []int{0, -1} {
+		l := newRateLimiters(1, entries)
+		now := time.Now()
+		if !l.allow("a", now) || !l.allow("b", now) {
+			t.Errorf("with %d entries, a new client wasn't allowed", entries)
+		}
+	}
+}
+
+func TestCooldowns(t *testing.T) {
+	c := newCooldowns(10*time.Second, 16)
+	now := time.Now()
+
+	if ok, _ := c.allow("a", now); !ok {
+		t.Fatal("first write was rejected")
+	}
+	ok, remaining := c.allow("a", now.Add(4*time.Second))
+	if ok || remaining != 6*time.Second {
+		t.Errorf("write during the cooldown = %v, %v; want rejected with 6s left", ok, remaining)
+	}
+	if ok, _ := c.allow("b", now.Add(4*time.Second)); !ok {
+		t.Error("another client was rejected by a's cooldown")
+	}
+	if ok, _ := c.allow("a", now.Add(10*time.Second)); !ok {
+		t.Error("write after the cooldown was rejected")
+	}
+}
+
+func TestCooldownsBounded(t *testing.T) {
+	c := newCooldowns(10*time.Second, 2)
+	now := time.Now()
+
+	c.allow("a", now)
+	c.allow("b", now.Add(time.Second))
+	// c evicts a, which wrote longest ago, although its cooldown isn't over
+	c.allow("c", now.Add(2*time.Second))
+	if len(c.entries) != 2 {
+		t.Fatalf("tracking %d clients, want 2", len(c.entries))
+	}
+	if ok, _ := c.allow("a", now.Add(3*time.Second)); !ok {
+		t.Error("evicted a was still in its cooldown")
+	}
+	if ok, _ := c.allow("c", now.Add(3*time.Second)); ok {
+		t.Error("c was allowed a write during its cooldown")
+	}
+
+	// once their cooldown is over, clients are dropped without a scan
+	c.allow("d", now.Add(time.Minute))
+	if len(c.entries) != 1 {
+		t.Errorf("tracking %d clients after all cooldowns ended, want 1", len(c.entries))
+	}
+}
+
+func TestCooldownRejection(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.cooldowns = newCooldowns(time.Minute, 16)
+	g.strict = true
+	c := dial(t, "tcp", listen(t, g))
+
+	got := c.do("PX 1 1 ff0000", "PX 2 2 00ff00")
+	if len(got) != 1 || got[0] != "ERROR cooldown 60" {
+		t.Errorf("second write within the cooldown replied %q, want ERROR cooldown 60", got)
+	}
+	canvas := g.Render()
+	if got := canvas.RGBAAt(1, 1); got != red {
+		t.Errorf("first write = %v, want %v", got, red)
+	}
+	if got := canvas.RGBAAt(2, 2); got != (color.RGBA{}) {
+		t.Errorf("write within the cooldown = %v, want it rejected", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+// regionSet is a set of canvas regions, such as the PRIORITY or PROTECT
+// regions.
+type regionSet struct {
+	mu    sync.RWMutex
+	rects []image.Rectangle
+	// count mirrors len(rects) so the write path can skip the mutex while
+	// the set is empty
+	count atomic.Int32
+}
+
+// add adds r to the set.
+func (p *regionSet) add(r image.Rectangle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rects = append(p.rects, r)
+	p.count.Store(int32(len(p.rects)))
+}
+
+// remove removes the regions overlapping r.
+func (p *regionSet) remove(r image.Rectangle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := p.rects[:0]
+	for _, rect := range p.rects {
+		if !rect.Overlaps(r) {
+			kept = append(kept, rect)
+		}
+	}
+	p.rects = kept
+	p.count.Store(int32(len(p.rects)))
+}
+
+// reset removes all regions.
+func (p *regionSet) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rects = nil
+	p.count.Store(0)
+}
+
+// contains reports whether pt lies in one of the regions.
+func (p *regionSet) contains(pt image.Point) bool {
+	if p.count.Load() == 0 {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, r := range p.rects {
+		if pt.In(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	solid := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(solid, solid.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+
+	for _, command := range []string{
+		"PX 3 3 ff0000",
+		"STAMP 0 0 solid",
+		"FLOODFILL 0 9 ff0000",
+		"DISC 5 5 5 ff0000",
+		"BRUSH 5 5 5 ff0000",
+		"PXCAS 3 3 00000000 ff0000",
+	} {
+		t.Run(command, func(t *testing.T) {
+			g := newTestGame(t, 10, 10)
+			g.sprites = map[string]*image.RGBA{"solid": solid}
+			c := connect(t, g)
+
+			// the top half is protected, a write below must still land
+			c.do("AUTH secret", "PROTECT 0 0 10 5", "PX 5 8 ff0000")
+			c.send(command)
+			if strings.HasPrefix(command, "FLOODFILL") {
+				renderTask(t, g)
+			}
+			c.sync()
+			canvas := g.Render()
+			for y := 0; y < 5; y++ {
+				for x := 0; x < 10; x++ {
+					if got := canvas.RGBAAt(x, y); got != (color.RGBA{}) {
+						t.Fatalf("protected pixel (%d, %d) = %v, want untouched", x, y, got)
+					}
+				}
+			}
+			if got := canvas.RGBAAt(5, 8); got != red {
+				t.Errorf("unprotected pixel = %v, want %v", got, red)
+			}
+		})
+	}
+}
+
+func TestProtectHeldWrites(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	admin, c := connect(t, g), connect(t, g)
+
+	// protected after the write was held, so only applying it can drop it
+	c.do("HOLD", "PX 1 1 ff0000", "PX 8 8 ff0000")
+	admin.do("AUTH secret", "PROTECT 0 0 5 5")
+	c.send("FLUSH")
+	renderTask(t, g)
+	c.sync()
+
+	canvas := g.Render()
+	if got := canvas.RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("held write into a protected region = %v, want dropped", got)
+	}
+	if got := canvas.RGBAAt(8, 8); got != red {
+		t.Errorf("held write elsewhere = %v, want %v", got, red)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// maxScheduledPixels bounds the pixels waiting for their PXAT frame.
+	maxScheduledPixels = 1 << 20
+	// maxScheduleAhead bounds how far ahead PXAT may schedule, about an
+	// hour at 60 frames per second.
+	maxScheduleAhead = 60 * 60 * 60
+)
+
+// pixelSchedule holds the pixel updates scheduled with PXAT, by frame.
+type pixelSchedule struct {
+	mu      sync.Mutex
+	frames  map[uint64][]PixelUpdate
+	pending int
+}
+
+// schedule queues update to be applied in the given frame.
+func (g *Game) schedule(frame uint64, update PixelUpdate) error {
+	g.scheduled.mu.Lock()
+	defer g.scheduled.mu.Unlock()
+
+	// the frame only advances while mu is held, see nextFrame
+	current := g.frameNumber.Load()
+	switch {
+	case frame <= current:
+		return errors.New("frame has passed")
+	case frame > current+maxScheduleAhead:
+		return errors.New("frame is too far ahead")
+	case g.scheduled.pending >= maxScheduledPixels:
+		return errors.New("too many scheduled pixels")
+	}
+
+	if g.scheduled.frames == nil {
+		g.scheduled.frames = make(map[uint64][]PixelUpdate)
+	}
+	g.scheduled.frames[frame] = append(g.scheduled.frames[frame], update)
+	g.scheduled.pending++
+	return nil
+}
+
+// nextFrame advances the frame number and returns the updates scheduled for
+// the new frame. It must only be called on the render goroutine.
+func (g *Game) nextFrame() []PixelUpdate {
+	g.scheduled.mu.Lock()
+	defer g.scheduled.mu.Unlock()
+
+	frame := g.frameNumber.Add(1)
+	updates := g.scheduled.frames[frame]
+	if updates != nil {
+		delete(g.scheduled.frames, frame)
+		g.scheduled.pending -= len(updates)
+	}
+	return updates
+}
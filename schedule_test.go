@@ -0,0 +1,26 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestScheduledPixel(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	c := connect(t, g)
+
+	if got := c.do("PXAT 3 1 1 ff0000", "PXAT 1000000000 0 0 ff0000"); len(got) != 1 || got[0] != "ERROR frame is too far ahead" {
+		t.Errorf("PXAT replied %q", got)
+	}
+	for frame := 1; frame < 3; frame++ {
+		if got := g.Render().RGBAAt(1, 1); got != (color.RGBA{}) {
+			t.Fatalf("pixel in frame %d = %v, want it drawn in frame 3", frame, got)
+		}
+	}
+	if got := g.Render().RGBAAt(1, 1); got != red {
+		t.Errorf("pixel in frame 3 = %v, want %v", got, red)
+	}
+	if got := c.do("FRAME", "PXAT 3 0 0 ff0000"); len(got) != 2 || got[0] != "FRAME 3" || got[1] != "ERROR frame has passed" {
+		t.Errorf("PXAT for the current frame replied %q", got)
+	}
+}
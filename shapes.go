@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// circle writes the outline of the circle of radius r around (cx, cy) with
+// the midpoint algorithm. Each pixel goes through writePixel like a PX
+// command; pixels off the canvas are skipped.
+func (g *Game) circle(state *connState, cx, cy, r int, c color.RGBA) {
+	width, height := g.size()
+	bounds := image.Rect(0, 0, width, height)
+
+	x, y, d := r, 0, 1-r
+	for x >= y {
+		octants := [8]image.Point{
+			{x, y}, {-x, y}, {x, -y}, {-x, -y},
+			{y, x}, {-y, x}, {y, -x}, {-y, -x},
+		}
+		for i, p := range octants {
+			// the octants meet on the axes and diagonals, don't blend
+			// those pixels twice
+			duplicate := false
+			for _, q := range octants[:i] {
+				duplicate = duplicate || p == q
+			}
+			p = p.Add(image.Point{cx, cy})
+			if !duplicate && p.In(bounds) {
+				g.writePixel(state, p.X, p.Y, c)
+			}
+		}
+
+		y++
+		if d < 0 {
+			d += 2*y + 1
+		} else {
+			x--
+			d += 2*(y-x) + 1
+		}
+	}
+}
+
+// disc fills the circle of radius r around (cx, cy) line by line. Like for
+// circle, each pixel goes through writePixel; pixels off the canvas are
+// skipped.
+func (g *Game) disc(state *connState, cx, cy, r int, c color.RGBA) {
+	width, height := g.size()
+	for dy := -r; dy <= r; dy++ {
+		y := cy + dy
+		if y < 0 || y >= height {
+			continue
+		}
+		half := int(math.Sqrt(float64(r*r - dy*dy)))
+		from := max(cx-half, 0)
+		to := min(cx+half, width-1)
+		for x := from; x <= to; x++ {
+			g.writePixel(state, x, y, c)
+		}
+	}
+}
+
+// brush dabs c onto the circle of radius r around (cx, cy). The color's
+// alpha falls off linearly from full at the center to nothing at the rim.
+// Each dab goes through writePixel; pixels off the canvas are skipped.
+func (g *Game) brush(state *connState, cx, cy, r int, c color.RGBA) {
+	width, height := g.size()
+	area := image.Rect(cx-r, cy-r, cx+r+1, cy+r+1).Intersect(image.Rect(0, 0, width, height))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dx, dy := x-cx, y-cy
+			falloff := 1.0
+			if r > 0 {
+				falloff = 1 - math.Hypot(float64(dx), float64(dy))/float64(r+1)
+			}
+			if falloff <= 0 {
+				continue
+			}
+
+			// c is premultiplied, so scaling all channels scales alpha
+			dab := color.RGBA{
+				uint8(float64(c.R)*falloff + 0.5),
+				uint8(float64(c.G)*falloff + 0.5),
+				uint8(float64(c.B)*falloff + 0.5),
+				uint8(float64(c.A)*falloff + 0.5),
+			}
+			if dab.A == 0 {
+				continue
+			}
+			g.writePixel(state, x, y, dab)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestCircle(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	c.do("CIRCLE 10 10 5 ff0000")
+	canvas := g.Render()
+	for _, p := range []image.Point{{15, 10}, {5, 10}, {10, 15}, {10, 5}} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != red {
+			t.Errorf("cardinal point %v = %v, want %v", p, got, red)
+		}
+	}
+	for _, p := range []image.Point{{10, 10}, {16, 10}, {10, 4}} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != (color.RGBA{}) {
+			t.Errorf("pixel %v off the outline = %v, want untouched", p, got)
+		}
+	}
+}
+
+func TestDisc(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	// partly off the canvas, which is clipped
+	c.do("DISC 10 10 5 ff0000", "DISC 0 0 3 00ff00")
+	canvas := g.Render()
+	for _, p := range []image.Point{{10, 10}, {15, 10}, {5, 10}, {10, 15}, {10, 5}, {13, 13}} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != red {
+			t.Errorf("pixel %v in the disc = %v, want %v", p, got, red)
+		}
+	}
+	for _, p := range []image.Point{{16, 10}, {14, 14}} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != (color.RGBA{}) {
+			t.Errorf("pixel %v outside the disc = %v, want untouched", p, got)
+		}
+	}
+	if got := canvas.RGBAAt(0, 0); got != green {
+		t.Errorf("clipped disc center = %v, want %v", got, green)
+	}
+}
+
+func TestDiscCooldown(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	g.cooldowns = newCooldowns(time.Hour, 16)
+	c := connect(t, g)
+
+	// only the first pixel of the disc gets past the cooldown
+	c.do("DISC 10 10 2 ff0000")
+	canvas := g.Render()
+	drawn := 0
+	for i := 3; i < len(canvas.Pix); i += 4 {
+		if canvas.Pix[i] != 0 {
+			drawn++
+		}
+	}
+	if drawn != 1 {
+		t.Errorf("DISC during a cooldown drew %d pixels, want 1", drawn)
+	}
+}
+
+func TestBrush(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	c := connect(t, g)
+
+	c.do("BRUSH 10 10 4 ff0000")
+	canvas := g.Render()
+	center, edge := canvas.RGBAAt(10, 10), canvas.RGBAAt(14, 10)
+	if center != red {
+		t.Errorf("brush center = %v, want %v", center, red)
+	}
+	if edge.A == 0 || edge.A >= center.A {
+		t.Errorf("brush edge alpha = %d, want between 0 and %d", edge.A, center.A)
+	}
+	if got := canvas.RGBAAt(15, 10); got != (color.RGBA{}) {
+		t.Errorf("pixel outside the brush = %v, want untouched", got)
+	}
+}
+
+func TestBrushCooldown(t *testing.T) {
+	g := newTestGame(t, 20, 20)
+	g.cooldowns = newCooldowns(time.Hour, 16)
+	c := connect(t, g)
+
+	c.do("BRUSH 10 10 2 ff0000")
+	canvas := g.Render()
+	drawn := 0
+	for i := 3; i < len(canvas.Pix); i += 4 {
+		if canvas.Pix[i] != 0 {
+			drawn++
+		}
+	}
+	if drawn != 1 {
+		t.Errorf("BRUSH during a cooldown drew %d pixels, want 1", drawn)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"sort"
+	"time"
+)
+
+// loadSlideshow loads every PNG in dir, in the order of their file names.
+func loadSlideshow(dir string) ([]*image.RGBA, error) {
+	images, err := loadSprites(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, errors.New("no PNG images in " + dir)
+	}
+
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	slides := make([]*image.RGBA, len(names))
+	for i, name := range names {
+		slides[i] = images[name]
+	}
+	return slides, nil
+}
+
+// runSlideshow replaces the canvas with each slide in turn for interval,
+// starting over after the last, until shutdown. Slides are placed at the top
+// left corner on black, so reads answer for exactly what is shown. The
+// slides are timed from the start, so waiting for a frame doesn't make them
+// drift.
+func (g *Game) runSlideshow(slides []*image.RGBA, interval time.Duration) {
+	next := g.clock.Now()
+	for i := 0; ; i = (i + 1) % len(slides) {
+		slide := slides[i]
+		g.queueTask(func() {
+			draw.Draw(g.canvas, g.canvas.Rect, image.Black, image.Point{}, draw.Src)
+			draw.Draw(g.canvas, slide.Rect.Intersect(g.canvas.Rect), slide, image.Point{}, draw.Src)
+			g.markDirty(g.canvas.Rect)
+		})
+
+		next = next.Add(interval)
+		select {
+		case <-g.done:
+			return
+		case <-g.clock.After(next.Sub(g.clock.Now())):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+)
+
+func TestSlideshowAdvances(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	var slides []*image.RGBA
+	for _, c := range []color.RGBA{red, green} {
+		slide := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		draw.Draw(slide, slide.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+		slides = append(slides, slide)
+	}
+	clock := newFakeClock()
+	g.clock = clock
+	go g.runSlideshow(slides, time.Second)
+
+	// each slide is queued before the slideshow waits for the next
+	clock.waitForWaiters(t, 1)
+	if got := g.Render().RGBAAt(1, 1); got != red {
+		t.Fatalf("first slide = %v, want %v", got, red)
+	}
+	clock.advance(999 * time.Millisecond)
+	if got := g.Render().RGBAAt(1, 1); got != red {
+		t.Errorf("slide before its interval ended = %v, want %v", got, red)
+	}
+
+	// the slides take turns, starting over after the last
+	for _, want := range []color.RGBA{green, red} {
+		clock.advance(time.Millisecond)
+		clock.waitForWaiters(t, 1)
+		if got := g.Render().RGBAAt(1, 1); got != want {
+			t.Errorf("next slide = %v, want %v", got, want)
+		}
+		clock.advance(999 * time.Millisecond)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxSnapshotLine bounds the length of a SNAPSHOT base64 reply. A busy
+// canvas compresses badly, so even a moderately sized one may produce a line
+// of several megabytes.
+const maxSnapshotLine = 64 << 20
+
+// snapshotBase64 returns the canvas as a base64-encoded PNG on a single line.
+func (g *Game) snapshotBase64() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	if err := png.Encode(encoder, g.Snapshot()); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+
+	if buf.Len() > maxSnapshotLine {
+		return nil, errors.New("canvas too large for a snapshot line")
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// autoSnapshots saves the canvas as a PNG to the snapshot directory every
+// interval until shutdown, keeping only the newest keep of these files.
+func (g *Game) autoSnapshots(interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := g.saveSnapshot(); err != nil {
+			log.Println("Error saving snapshot:", err)
+			continue
+		}
+		if err := pruneSnapshots(g.snapshotDir, keep); err != nil {
+			log.Println("Error removing old snapshots:", err)
+		}
+	}
+}
+
+// saveSnapshot writes the canvas as a PNG to the snapshot directory.
+func (g *Game) saveSnapshot() error {
+	img := g.Snapshot()
+
+	if err := os.MkdirAll(g.snapshotDir, 0o755); err != nil {
+		return err
+	}
+	// the timestamp sorts like the time, which pruneSnapshots relies on
+	path := filepath.Join(g.snapshotDir, "auto-"+time.Now().Format("20060102-150405.000")+".png")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pruneSnapshots removes all but the newest keep automatic snapshots from
+// dir.
+func pruneSnapshots(dir string, keep int) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "auto-*.png"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
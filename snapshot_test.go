@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBase64(t *testing.T) {
+	g := newTestGame(t, 3, 2)
+	c := connect(t, g)
+	c.do("PX 0 0 ff0000", "PX 2 1 0000ff")
+	want := g.Render()
+
+	got := c.do("SNAPSHOT base64")
+	if len(got) != 1 {
+		t.Fatalf("SNAPSHOT base64 replied %d lines, want 1", len(got))
+	}
+	data, err := base64.StdEncoding.DecodeString(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != want.Rect {
+		t.Fatalf("PNG bounds = %v, want %v", img.Bounds(), want.Rect)
+	}
+	decoded := image.NewRGBA(img.Bounds())
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			decoded.Set(x, y, img.At(x, y))
+		}
+	}
+	if !bytes.Equal(decoded.Pix, want.Pix) {
+		t.Errorf("decoded PNG = %v, want %v", decoded.Pix, want.Pix)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	const keep = 3
+	g := newTestGame(t, 2, 2)
+	other := filepath.Join(g.snapshotDir, "owners-20240101-000000.000.png")
+	if err := os.WriteFile(other, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// what autoSnapshots does each interval, for keep+2 intervals
+	var saved []string
+	for i := 0; i < keep+2; i++ {
+		before, _ := filepath.Glob(filepath.Join(g.snapshotDir, "auto-*.png"))
+		if err := g.saveSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := filepath.Glob(filepath.Join(g.snapshotDir, "auto-*.png"))
+		saved = append(saved, newest(before, after))
+		if err := pruneSnapshots(g.snapshotDir, keep); err != nil {
+			t.Fatal(err)
+		}
+		// snapshot names have millisecond resolution
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	got, _ := filepath.Glob(filepath.Join(g.snapshotDir, "auto-*.png"))
+	if want := saved[len(saved)-keep:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("snapshots left = %q, want the newest %q", got, want)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("pruning removed another file: %v", err)
+	}
+}
+
+// newest returns the path in after that isn't in before.
+func newest(before, after []string) string {
+	for _, path := range after {
+		if !slices.Contains(before, path) {
+			return path
+		}
+	}
+	return ""
+}
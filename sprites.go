@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadSprites loads every PNG in dir as a sprite named after the file,
+// without its extension.
+func loadSprites(dir string) (map[string]*image.RGBA, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, err
+	}
+
+	sprites := make(map[string]*image.RGBA, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		sprite := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(sprite, sprite.Rect, img, img.Bounds().Min, draw.Src)
+		sprites[strings.TrimSuffix(filepath.Base(path), ".png")] = sprite
+	}
+	return sprites, nil
+}
+
+// stamp writes sprite for the connection with its top left corner at the
+// canvas coordinates (x, y). Every pixel is admitted like a PX, and fully
+// transparent sprite pixels are skipped.
+func (g *Game) stamp(state *connState, sprite *image.RGBA, x, y int) {
+	width, height := g.size()
+	r := sprite.Rect.Add(image.Point{x, y}).Intersect(image.Rect(0, 0, width, height))
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			c := sprite.RGBAAt(px-x, py-y)
+			if c.A == 0 {
+				continue
+			}
+			g.writePixel(state, px, py, c)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// checker returns a 2x2 sprite with red and blue on one diagonal and
+// transparent pixels on the other.
+func checker() *image.RGBA {
+	sprite := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	sprite.SetRGBA(0, 0, red)
+	sprite.SetRGBA(1, 1, blue)
+	return sprite
+}
+
+func TestStamp(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.sprites = map[string]*image.RGBA{"checker": checker()}
+	c := connect(t, g)
+
+	c.do("PX 4 3 00ff00", "STAMP 3 3 checker")
+	canvas := g.Render()
+
+	want := map[image.Point]color.RGBA{
+		{3, 3}: red,
+		{4, 4}: blue,
+		// transparent sprite pixels leave the canvas alone
+		{4, 3}: green,
+		{3, 4}: {},
+	}
+	for p, want := range want {
+		if got := canvas.RGBAAt(p.X, p.Y); got != want {
+			t.Errorf("pixel %v after STAMP = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestStampCooldown(t *testing.T) {
+	g := newTestGame(t, 10, 10)
+	g.sprites = map[string]*image.RGBA{"checker": checker()}
+	g.cooldowns = newCooldowns(time.Hour, 16)
+	c := connect(t, g)
+
+	// the first sprite pixel uses up the cooldown
+	c.do("STAMP 0 0 checker")
+	canvas := g.Render()
+	if got := canvas.RGBAAt(0, 0); got != red {
+		t.Errorf("first stamped pixel = %v, want %v", got, red)
+	}
+	if got := canvas.RGBAAt(1, 1); got != (color.RGBA{}) {
+		t.Errorf("second stamped pixel = %v, want it rejected by the cooldown", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// encodeState returns a STATE reply: a "STATE <w> <h>" line followed by
+// the raw RGBA canvas.
+func (g *Game) encodeState() []byte {
+	g.canvasMu.RLock()
+	defer g.canvasMu.RUnlock()
+
+	header := fmt.Sprintf("STATE %d %d\n", g.canvas.Rect.Dx(), g.canvas.Rect.Dy())
+	reply := make([]byte, 0, len(header)+len(g.canvas.Pix))
+	reply = append(reply, header...)
+	return append(reply, g.canvas.Pix...)
+}
+
+// putState reads n bytes of deflate-compressed raw RGBA from r and replaces
+// the whole canvas with them on the render goroutine. The payload is always
+// consumed completely, so the connection stays in sync even if it is
+// rejected.
+func (g *Game) putState(r io.Reader, n int64) error {
+	payload := io.LimitReader(r, n)
+	defer io.Copy(io.Discard, payload)
+
+	width, height := g.size()
+	pix := make([]byte, width*height*4)
+
+	decompressor := flate.NewReader(payload)
+	defer decompressor.Close()
+
+	_, err := io.ReadFull(decompressor, pix)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return errors.New("state is smaller than the canvas")
+	} else if err != nil {
+		return err
+	}
+	if n, _ := decompressor.Read(make([]byte, 1)); n != 0 {
+		return errors.New("state is larger than the canvas")
+	}
+
+	g.queueTask(func() {
+		// the canvas may have been resized since the size was checked
+		if len(g.canvas.Pix) == len(pix) {
+			copy(g.canvas.Pix, pix)
+			g.markDirty(g.canvas.Rect)
+		}
+	})
+	return nil
+}
+
+// maxRegionBytes bounds the payload of a REGIONSET to as many pixels as a
+// connection may hold back.
+const maxRegionBytes = maxHeldPixels * 4
+
+// putRegion reads the straight RGBA pixels of r, row by row, from payload
+// and replaces that region of the canvas with them in a single render task,
+// so they all appear in the same frame. Every pixel is admitted like a PX
+// write by state as it is read, so cooldowns, rate limits, protected and
+// locked regions apply to each of them, and is then drawn like one. While
+// state is holding, the pixels are held instead. Pixels outside the canvas
+// are dropped. The payload of w*h*4 bytes is always consumed completely.
+func (g *Game) putRegion(payload io.Reader, r image.Rectangle, state *connState) error {
+	n := int64(r.Dx()) * int64(r.Dy()) * 4
+	if n > maxRegionBytes {
+		io.Copy(io.Discard, io.LimitReader(payload, n))
+		return errors.New("region too large")
+	}
+	region := &image.NRGBA{Pix: make([]byte, n), Stride: r.Dx() * 4, Rect: r}
+	if _, err := io.ReadFull(payload, region.Pix); err != nil {
+		return errors.New("truncated REGIONSET pixels")
+	}
+
+	width, height := g.size()
+	target := r.Intersect(image.Rect(0, 0, width, height))
+	updates := make([]PixelUpdate, 0, target.Dx()*target.Dy())
+	for y := target.Min.Y; y < target.Max.Y; y++ {
+		for x := target.Min.X; x < target.Max.X; x++ {
+			c := color.RGBAModel.Convert(region.NRGBAAt(x, y)).(color.RGBA)
+			update, ok := g.admitPixel(state, x, y, c)
+			if !ok {
+				continue
+			}
+			update.blend = blendReplace
+			updates = append(updates, update)
+		}
+	}
+
+	if state.holding {
+		room := max(maxHeldPixels-len(state.held), 0)
+		if len(updates) > room {
+			g.stats.dropped.Add(uint64(len(updates) - room))
+			updates = updates[:room]
+		}
+		state.held = append(state.held, updates...)
+		return nil
+	}
+	g.queueTask(func() {
+		for _, update := range updates {
+			g.applyUpdate(update)
+		}
+		g.stats.pixels.Add(uint64(len(updates)))
+	})
+	return nil
+}
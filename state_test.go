@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+	"time"
+)
+
+// deflate compresses b.
+func deflate(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPutStateRoundTrip(t *testing.T) {
+	g := newTestGame(t, 4, 3)
+	want := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for i := range want.Pix {
+		want.Pix[i] = byte(i * 7)
+	}
+	for i := 3; i < len(want.Pix); i += 4 {
+		want.Pix[i] = 255
+	}
+	payload := deflate(t, want.Pix)
+
+	c := connect(t, g)
+	c.do("AUTH secret")
+	c.send(fmt.Sprintf("PUTSTATE %d", len(payload)))
+	c.conn.Write(payload)
+	if got := c.sync(); len(got) != 0 {
+		t.Fatalf("PUTSTATE replied %q", got)
+	}
+	g.Render()
+
+	if got := g.Snapshot(); !bytes.Equal(got.Pix, want.Pix) {
+		t.Errorf("canvas after PUTSTATE = %v, want %v", got.Pix, want.Pix)
+	}
+}
+
+func TestPutStateUnauthorizedSkipsPayload(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	// a payload that would draw a pixel if it were read as commands
+	payload := []byte("PX 0 0 ff0000\n")
+
+	c := connect(t, g)
+	c.send(fmt.Sprintf("PUTSTATE %d", len(payload)))
+	c.conn.Write(payload)
+	if got := c.sync(); len(got) != 1 || got[0] != "ERROR unauthorized" {
+		t.Fatalf("unauthorized PUTSTATE replied %q", got)
+	}
+	g.Render()
+	if got := g.pixel(0, 0); got == red {
+		t.Error("the PUTSTATE payload was run as a command")
+	}
+}
+
+func TestCompressedState(t *testing.T) {
+	g := newTestGame(t, 3, 2)
+	c := connect(t, g)
+	c.do("PX 1 1 ff0000")
+	want := g.Render()
+
+	c.send("COMPRESS on")
+	if got := c.readLine(); got != "COMPRESS on" {
+		t.Fatalf("COMPRESS on replied %q", got)
+	}
+	c.send("STATE")
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(flate.NewReader(c.r))
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "STATE 3 2\n" {
+		t.Fatalf("STATE header = %q", header)
+	}
+	pix := make([]byte, len(want.Pix))
+	if _, err := io.ReadFull(r, pix); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pix, want.Pix) {
+		t.Errorf("decompressed STATE = %v, want %v", pix, want.Pix)
+	}
+}
+
+func TestEmptyReplyDoesNotCompress(t *testing.T) {
+	g := newTestGame(t, 3, 2)
+	g.RegisterCommand("NOTHING", func(args []string, w io.Writer, state *connState) error {
+		_, err := w.Write(nil)
+		return err
+	})
+	c := connect(t, g)
+
+	// SIZE must still come back as plain text
+	if got := c.do("NOTHING", "SIZE"); len(got) != 1 || got[0] != "SIZE 3 2" {
+		t.Errorf("replies after an empty write = %q", got)
+	}
+}
+
+func TestRegionSet(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+
+	c.send("REGIONSET 1 1 2 2")
+	c.conn.Write([]byte{
+		255, 0, 0, 255, 0, 255, 0, 255,
+		0, 0, 255, 255, 255, 255, 255, 255,
+	})
+	if got := c.sync(); len(got) != 0 {
+		t.Fatalf("REGIONSET replied %q", got)
+	}
+
+	// all four pixels land in the same frame
+	canvas := g.Render()
+	for _, p := range []struct {
+		x, y int
+		want color.RGBA
+	}{{1, 1, red}, {2, 1, green}, {1, 2, blue}, {2, 2, white}} {
+		if got := canvas.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+}
+
+func TestRegionSetProtected(t *testing.T) {
+	g := newTestGame(t, 2, 1)
+	admin, c := connect(t, g), connect(t, g)
+	admin.do("AUTH secret", "PROTECT 0 0 1 1")
+
+	c.send("REGIONSET 0 0 2 1")
+	c.conn.Write([]byte{255, 0, 0, 255, 255, 0, 0, 255})
+	c.sync()
+	canvas := g.Render()
+	if got := canvas.RGBAAt(0, 0); got != (color.RGBA{}) {
+		t.Errorf("protected pixel = %v, want untouched", got)
+	}
+	if got := canvas.RGBAAt(1, 0); got != red {
+		t.Errorf("unprotected pixel = %v, want %v", got, red)
+	}
+}
+
+func TestRegionSetLimited(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(g *Game)
+		want  int
+	}{
+		{"cooldown", func(g *Game) { g.cooldowns = newCooldowns(time.Hour, 16) }, 1},
+		{"rate-limit", func(g *Game) { g.limiters = newRateLimiters(2, 16) }, 2},
+		// (0, 0) was just written, so the guard holds it
+		{"pixel-cooldown-frames", func(g *Game) { g.flicker = newFlickerGuard(3, g.canvas.Rect) }, 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// the same four red pixels, written with PX and with REGIONSET
+			for _, region := range []bool{false, true} {
+				g := newTestGame(t, 2, 2)
+				test.setup(g)
+				g.enqueue(PixelUpdate{x: 0, y: 0, color: green})
+				g.Render()
+
+				c := connect(t, g)
+				if region {
+					c.send("REGIONSET 0 0 2 2")
+					c.conn.Write(bytes.Repeat([]byte{255, 0, 0, 255}, 4))
+				} else {
+					c.send("PX 0 0 ff0000", "PX 1 0 ff0000", "PX 0 1 ff0000", "PX 1 1 ff0000")
+				}
+				c.sync()
+				canvas := g.Render()
+				drawn := 0
+				for i := 0; i < len(canvas.Pix); i += 4 {
+					if canvas.Pix[i] == 255 {
+						drawn++
+					}
+				}
+				if drawn != test.want {
+					t.Errorf("REGIONSET %v: drew %d red pixels, want %d", region, drawn, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegionSetReadOnlySkipsPayload(t *testing.T) {
+	g := newTestGame(t, 2, 2)
+	g.readOnly = true
+	// 16 bytes that would get a reply if they were read as a command
+	payload := []byte("PING leaked!!!!\n")
+
+	c := connect(t, g)
+	c.send("REGIONSET 0 0 2 2")
+	c.conn.Write(payload)
+	if got := c.sync(); len(got) != 0 {
+		t.Errorf("read-only REGIONSET replied %q, want the payload skipped", got)
+	}
+}
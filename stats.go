@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// stats holds server-wide counters. They are updated with atomics so the
+// hot path never takes a lock for them.
+type stats struct {
+	connections atomic.Int64
+	pixels      atomic.Uint64 // pixel updates applied to the canvas
+	dropped     atomic.Uint64 // pixel writes discarded before reaching the canvas
+
+	// pixel rate, sampled on the render goroutine
+	sampledAt     time.Time
+	sampledPixels uint64
+	pixelRate     atomic.Uint64 // float64 bits
+}
+
+// pixelsPerSec returns the most recently sampled pixel rate.
+func (s *stats) pixelsPerSec() float64 {
+	return math.Float64frombits(s.pixelRate.Load())
+}
+
+// samplePixelRate updates the pixel rate roughly once per second. It must
+// only be called on the render goroutine.
+func (s *stats) samplePixelRate(now time.Time) {
+	elapsed := now.Sub(s.sampledAt)
+	if elapsed < time.Second {
+		return
+	}
+
+	pixels := s.pixels.Load()
+	if !s.sampledAt.IsZero() {
+		s.pixelRate.Store(math.Float64bits(float64(pixels-s.sampledPixels) / elapsed.Seconds()))
+	}
+	s.sampledAt = now
+	s.sampledPixels = pixels
+}
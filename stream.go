@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"time"
+)
+
+// streamFrames pushes a delta frame to the connection whenever the canvas
+// changed, at most streamFPS times per second, until the connection closes.
+//
+// Each frame is a big-endian uint32 payload length followed by one 8 byte
+// record per changed pixel: x and y as big-endian uint16, then R, G, B, A.
+// The first frame, and the first one after a resize, contains every pixel
+// that isn't fully transparent.
+func (g *Game) streamFrames(state *connState) {
+	defer state.workers.Done()
+
+	ticker := time.NewTicker(time.Second / time.Duration(g.streamFPS))
+	defer ticker.Stop()
+
+	var previous, current *image.RGBA
+	for {
+		select {
+		case <-state.done:
+			return
+		case <-ticker.C:
+		}
+
+		if len(state.out) > 0 {
+			// the client hasn't caught up with earlier replies, skip a frame
+			continue
+		}
+
+		g.canvasMu.RLock()
+		if current == nil || current.Rect != g.canvas.Rect {
+			current = image.NewRGBA(g.canvas.Rect)
+		}
+		copy(current.Pix, g.canvas.Pix)
+		g.canvasMu.RUnlock()
+
+		if previous == nil || previous.Rect != current.Rect {
+			previous = image.NewRGBA(current.Rect)
+		}
+
+		if frame := encodeDeltaFrame(previous, current); frame != nil {
+			state.write(frame)
+		}
+		previous, current = current, previous
+	}
+}
+
+// encodeDeltaFrame returns a stream frame holding the pixels that differ
+// between previous and current, or nil if there are none.
+func encodeDeltaFrame(previous, current *image.RGBA) []byte {
+	frame := make([]byte, 4, 4+1024)
+	for y := 0; y < current.Rect.Dy(); y++ {
+		for x := 0; x < current.Rect.Dx(); x++ {
+			i := current.PixOffset(x, y)
+			p, c := previous.Pix[i:i+4], current.Pix[i:i+4]
+			if p[0] == c[0] && p[1] == c[1] && p[2] == c[2] && p[3] == c[3] {
+				continue
+			}
+			frame = binary.BigEndian.AppendUint16(frame, uint16(x))
+			frame = binary.BigEndian.AppendUint16(frame, uint16(y))
+			frame = append(frame, c...)
+		}
+	}
+
+	if len(frame) == 4 {
+		return nil
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	return frame
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestEncodeDeltaFrame(t *testing.T) {
+	previous := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	current := image.NewRGBA(previous.Rect)
+	if frame := encodeDeltaFrame(previous, current); frame != nil {
+		t.Errorf("frame without changes = %v, want nil", frame)
+	}
+
+	current.SetRGBA(2, 3, red)
+	want := []byte{0, 0, 0, 8, 0, 2, 0, 3, 255, 0, 0, 255}
+	if frame := encodeDeltaFrame(previous, current); !bytes.Equal(frame, want) {
+		t.Errorf("frame = %v, want %v", frame, want)
+	}
+}
+
+func TestStreamDelta(t *testing.T) {
+	g := newTestGame(t, 4, 4)
+	c := connect(t, g)
+	if got := c.do("STREAM on"); len(got) != 1 || got[0] != "STREAM on" {
+		t.Fatalf("STREAM on replied %q", got)
+	}
+
+	c.do("PX 2 3 ff0000")
+	g.Render()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var length uint32
+	if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+		t.Fatal(err)
+	}
+	records := make([]byte, length)
+	if _, err := io.ReadFull(c.r, records); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 2, 0, 3, 255, 0, 0, 255}
+	if !bytes.Equal(records, want) {
+		t.Errorf("delta frame records = %v, want %v", records, want)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeSVG encodes img as an SVG document. Horizontal runs of equal pixels
+// become a single rect, fully transparent pixels are left out.
+func writeSVG(w io.Writer, img *image.RGBA) error {
+	bw := bufio.NewWriter(w)
+	bounds := img.Rect
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		bounds.Dx(), bounds.Dy(), bounds.Dx(), bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; {
+			c := img.RGBAAt(x, y)
+			run := 1
+			for x+run < bounds.Max.X && img.RGBAAt(x+run, y) == c {
+				run++
+			}
+			if c.A != 0 {
+				writeSVGRect(bw, x-bounds.Min.X, y-bounds.Min.Y, run, c)
+			}
+			x += run
+		}
+	}
+
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+func writeSVGRect(w io.Writer, x, y, width int, c color.RGBA) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="1" fill="#%02x%02x%02x"`, x, y, width, n.R, n.G, n.B)
+	if n.A != 255 {
+		fmt.Fprintf(w, ` fill-opacity="%.3f"`, float64(n.A)/255)
+	}
+	io.WriteString(w, "/>\n")
+}
+
+// exportSVG writes the canvas as an SVG file to the snapshot directory and
+// returns its path.
+func (g *Game) exportSVG() (string, error) {
+	img := g.Snapshot()
+
+	if err := os.MkdirAll(g.snapshotDir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(g.snapshotDir, "canvas-"+time.Now().Format("20060102-150405.000")+".svg")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := writeSVG(f, img); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
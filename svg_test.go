@@ -0,0 +1,28 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestWriteSVG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.SetRGBA(0, 0, red)
+	img.SetRGBA(1, 0, red)
+	img.SetRGBA(0, 1, color.RGBA{0, 0, 128, 128})
+
+	var b strings.Builder
+	if err := writeSVG(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="2" height="2" viewBox="0 0 2 2" shape-rendering="crispEdges">
+<rect x="0" y="0" width="2" height="1" fill="#ff0000"/>
+<rect x="0" y="1" width="1" height="1" fill="#0000ff" fill-opacity="0.502"/>
+</svg>
+`
+	if b.String() != want {
+		t.Errorf("SVG =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "image/color"
+
+// testPattern computes the color of the pixel at (x, y) on a canvas of the
+// given size.
+type testPattern func(x, y, width, height int) color.RGBA
+
+// smpteBars are the 75% color bars of the SMPTE pattern, left to right.
+var smpteBars = [7]color.RGBA{
+	{191, 191, 191, 255}, // gray
+	{191, 191, 0, 255},   // yellow
+	{0, 191, 191, 255},   // cyan
+	{0, 191, 0, 255},     // green
+	{191, 0, 191, 255},   // magenta
+	{191, 0, 0, 255},     // red
+	{0, 0, 191, 255},     // blue
+}
+
+// testPatterns maps the -testpattern names to patterns.
+var testPatterns = map[string]testPattern{
+	// seven bars on the upper two thirds, a black to white ramp below
+	"bars": func(x, y, width, height int) color.RGBA {
+		if y < height*2/3 {
+			return smpteBars[x*len(smpteBars)/width]
+		}
+		v := uint8(x * 255 / max(width-1, 1))
+		return color.RGBA{v, v, v, 255}
+	},
+	// red increasing to the right, green increasing downwards
+	"gradient": func(x, y, width, height int) color.RGBA {
+		return color.RGBA{
+			uint8(x * 255 / max(width-1, 1)),
+			uint8(y * 255 / max(height-1, 1)),
+			128,
+			255,
+		}
+	},
+}
+
+// fillTestPattern queues a render task drawing pattern over the whole
+// canvas.
+func (g *Game) fillTestPattern(pattern testPattern) {
+	g.queueTask(func() {
+		bounds := g.canvas.Rect
+		g.markDirty(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				g.canvas.SetRGBA(x, y, pattern(x-bounds.Min.X, y-bounds.Min.Y, bounds.Dx(), bounds.Dy()))
+			}
+		}
+	})
+}
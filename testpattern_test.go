@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestTestPatterns(t *testing.T) {
+	g := newTestGame(t, 70, 30)
+	g.fillTestPattern(testPatterns["bars"])
+	canvas := g.Render()
+
+	// the middle of each bar, and the ramp below
+	for i, want := range smpteBars {
+		if got := canvas.RGBAAt(i*10+5, 10); got != want {
+			t.Errorf("bar %d = %v, want %v", i, got, want)
+		}
+	}
+	if got := canvas.RGBAAt(0, 25); got != black {
+		t.Errorf("left end of the ramp = %v, want %v", got, black)
+	}
+	if got := canvas.RGBAAt(69, 25); got != white {
+		t.Errorf("right end of the ramp = %v, want %v", got, white)
+	}
+
+	g.fillTestPattern(testPatterns["gradient"])
+	canvas = g.Render()
+	for _, test := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0, 0, 128, 255}},
+		{69, 0, color.RGBA{255, 0, 128, 255}},
+		{0, 29, color.RGBA{0, 255, 128, 255}},
+		{69, 29, color.RGBA{255, 255, 128, 255}},
+	} {
+		if got := canvas.RGBAAt(test.x, test.y); got != test.want {
+			t.Errorf("gradient at (%d,%d) = %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// windowDisplay is the Display of the ebiten window, used unless the canvas
+// is rendered to another device. It keeps a copy of what the window shows
+// and uploads the rows changed since the last Flush to the frame image with
+// a single WritePixels. ebiten pulls each frame by calling Draw, which
+// updates it, see updateWindow.
+type windowDisplay struct {
+	frame *ebiten.Image
+	pix   []byte
+	// dirty bounds the pixels set since the last Flush
+	dirty image.Rectangle
+}
+
+// fit makes the display the size of the canvas bounds. It reports whether
+// the display had to be recreated for that, which leaves it blank.
+func (w *windowDisplay) fit(bounds image.Rectangle) bool {
+	if w.frame != nil && w.frame.Bounds() == bounds {
+		return false
+	}
+	if w.frame != nil {
+		w.frame.Dispose()
+	}
+	w.frame = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	w.pix = make([]byte, bounds.Dx()*bounds.Dy()*4)
+	w.dirty = image.Rectangle{}
+	return true
+}
+
+// Set shows the premultiplied color c at (x, y).
+func (w *windowDisplay) Set(x, y int, c color.RGBA) {
+	if w.frame == nil || !(image.Point{x, y}.In(w.frame.Bounds())) {
+		return
+	}
+	i := (y*w.frame.Bounds().Dx() + x) * 4
+	w.pix[i], w.pix[i+1], w.pix[i+2], w.pix[i+3] = c.R, c.G, c.B, c.A
+	w.dirty = w.dirty.Union(image.Rect(x, y, x+1, y+1))
+}
+
+// SetRegion copies the pixels of src within r row by row. The window draws
+// the background beneath them itself, scaled to the screen.
+func (w *windowDisplay) SetRegion(src *image.RGBA, r image.Rectangle) {
+	if w.frame == nil {
+		return
+	}
+	r = r.Intersect(w.frame.Bounds()).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	stride := w.frame.Bounds().Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		copy(w.pix[y*stride+r.Min.X*4:], src.Pix[i:i+r.Dx()*4])
+	}
+	w.dirty = w.dirty.Union(r)
+}
+
+// Flush uploads the full rows holding the pixels set since the last Flush,
+// which are contiguous in pix, so no copy is needed.
+func (w *windowDisplay) Flush() {
+	if w.frame == nil || w.dirty.Empty() {
+		return
+	}
+	bounds := w.frame.Bounds()
+	rows := image.Rect(bounds.Min.X, w.dirty.Min.Y, bounds.Max.X, w.dirty.Max.Y)
+	w.dirty = image.Rectangle{}
+	stride := bounds.Dx() * 4
+	pix := w.pix[rows.Min.Y*stride : rows.Max.Y*stride]
+	if rows == bounds {
+		w.frame.WritePixels(pix)
+		return
+	}
+	w.frame.SubImage(rows).(*ebiten.Image).WritePixels(pix)
+}